@@ -3,14 +3,19 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"github.com/hbrawnak/go-linko/internal/blocklist"
 	"github.com/hbrawnak/go-linko/internal/data"
 	"github.com/hbrawnak/go-linko/internal/database"
+	"github.com/hbrawnak/go-linko/internal/geoip"
 	"github.com/hbrawnak/go-linko/internal/handlers"
 	"github.com/hbrawnak/go-linko/internal/routes"
 	"github.com/hbrawnak/go-linko/internal/service"
+	"github.com/hbrawnak/go-linko/internal/utils"
 	"github.com/hbrawnak/go-linko/internal/worker"
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v5"
@@ -20,12 +25,19 @@ import (
 const port = "8080"
 
 type Config struct {
-	DB      *sql.DB
-	Service *service.Service
-	Queue   chan worker.URLTask
+	DB         *sql.DB
+	Service    *service.Service
+	Queue      chan worker.URLTask
+	BatchQueue chan []worker.URLTask
 }
 
 func NewConfig() *Config {
+	policy, err := utils.LoadURLPolicyFromEnv()
+	if err != nil {
+		log.Panic(err)
+	}
+	utils.SetURLPolicy(policy)
+
 	db := database.ConnectToDB()
 	if db == nil {
 		log.Panic("Failed to connect to database")
@@ -38,18 +50,41 @@ func NewConfig() *Config {
 
 	models := data.New(db)
 
+	networkGuard := service.NewNetworkGuardFromEnv()
+
+	blocklists, err := blocklist.New(
+		utils.SplitList(os.Getenv("BLOCKLIST_FILES")),
+		utils.SplitList(os.Getenv("BLOCKLIST_HASH_FILES")),
+	)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	geoDB, err := geoip.Open(os.Getenv("GEOIP_DATABASE"))
+	if err != nil {
+		log.Panic(err)
+	}
+
 	svc := &service.Service{
-		Models: models,
-		Redis:  *redisClient,
+		Models:              models,
+		Redis:               *redisClient,
+		StripTrackingParams: utils.GetEnvBool("NORMALIZE_STRIP_TRACKING"),
+		Chain:               service.LoadChainPolicyFromEnv(networkGuard),
+		Network:             networkGuard,
+		Blocklist:           blocklists,
+		GeoIP:               geoDB,
+		Metadata:            service.NewMetadataFetcherFromEnv(networkGuard),
 	}
 
 	// Create task queue channel
 	taskQueue := make(chan worker.URLTask, 10)
+	batchQueue := make(chan []worker.URLTask, 10)
 
 	return &Config{
-		DB:      db,
-		Service: svc,
-		Queue:   taskQueue,
+		DB:         db,
+		Service:    svc,
+		Queue:      taskQueue,
+		BatchQueue: batchQueue,
 	}
 }
 
@@ -60,9 +95,16 @@ func main() {
 
 	// Start worker goroutine to process tasks from queue
 	go worker.StartURLTaskWorker(app.Queue, app.Service)
+	go worker.StartURLBatchTaskWorker(app.BatchQueue, app.Service)
+
+	// Fetch destination metadata of new links in the background
+	app.Service.StartMetadataWorkers()
+
+	// Reload blocklists when their files change
+	go app.Service.Blocklist.Watch(utils.GetEnvDuration("BLOCKLIST_RELOAD_INTERVAL", 30*time.Second), nil)
 
 	// Create handler with service dependency
-	handler := handlers.NewHandler(app.Service, app.Queue)
+	handler := handlers.NewHandler(app.Service, app.Queue, app.BatchQueue)
 
 	svr := http.Server{
 		Addr:    fmt.Sprintf(":%s", port),
@@ -0,0 +1,296 @@
+package blocklist
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/hbrawnak/go-linko/internal/utils"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Blocklist matches destinations against local lists of known-bad sites.
+// Plain lists hold one domain ("example.com", "*.example.com") or full URL
+// per line. Hash lists hold hex encoded SHA-256 prefixes of Safe Browsing
+// style URL expressions such as "evil.example.com/path". Lists are reloaded
+// when their files change.
+type Blocklist struct {
+	plainFiles []string
+	hashFiles  []string
+
+	mu      sync.RWMutex
+	lists   *lists
+	modTime map[string]time.Time
+}
+
+type lists struct {
+	hosts    []namedHostList
+	urls     map[string]string // normalized url -> list name
+	prefixes map[int]map[string]string
+}
+
+// namedHostList is the hosts of one plain list. Lists are kept per file, as
+// files in different directories may share a name.
+type namedHostList struct {
+	name  string
+	hosts *utils.HostList
+}
+
+// New loads the given files. Either slice may be empty.
+func New(plainFiles, hashFiles []string) (*Blocklist, error) {
+	b := &Blocklist{
+		plainFiles: plainFiles,
+		hashFiles:  hashFiles,
+	}
+
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// Match reports whether u is on a blocklist and, if so, the name of the list.
+func (b *Blocklist) Match(u string) (string, bool) {
+	if b == nil {
+		return "", false
+	}
+
+	b.mu.RLock()
+	l := b.lists
+	b.mu.RUnlock()
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", false
+	}
+
+	for _, list := range l.hosts {
+		if list.hosts.Contains(parsed.Hostname()) {
+			return list.name, true
+		}
+	}
+
+	if normalized, err := utils.NormalizeURL(u, false); err == nil {
+		if name, ok := l.urls[normalized]; ok {
+			return name, true
+		}
+	}
+
+	if len(l.prefixes) > 0 {
+		for _, expr := range urlExpressions(parsed) {
+			sum := sha256.Sum256([]byte(expr))
+			for size, set := range l.prefixes {
+				if name, ok := set[string(sum[:size])]; ok {
+					return name, true
+				}
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Reload reads every list from disk and swaps them in at once. On error the
+// previous lists stay in use.
+func (b *Blocklist) Reload() error {
+	l := &lists{
+		urls:     make(map[string]string),
+		prefixes: make(map[int]map[string]string),
+	}
+	modTime := make(map[string]time.Time)
+
+	for _, path := range b.plainFiles {
+		if err := l.loadPlain(path); err != nil {
+			return err
+		}
+		modTime[path] = fileModTime(path)
+	}
+
+	for _, path := range b.hashFiles {
+		if err := l.loadHashes(path); err != nil {
+			return err
+		}
+		modTime[path] = fileModTime(path)
+	}
+
+	b.mu.Lock()
+	b.lists = l
+	b.modTime = modTime
+	b.mu.Unlock()
+
+	return nil
+}
+
+// Watch polls the list files every interval and reloads them when any has
+// changed, until stop is closed.
+func (b *Blocklist) Watch(interval time.Duration, stop <-chan struct{}) {
+	if len(b.plainFiles)+len(b.hashFiles) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if !b.changed() {
+				continue
+			}
+
+			if err := b.Reload(); err != nil {
+				log.Printf("blocklist reload failed, keeping previous lists: %v", err)
+				continue
+			}
+			log.Println("Blocklists reloaded")
+		}
+	}
+}
+
+func (b *Blocklist) changed() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for path, seen := range b.modTime {
+		if !fileModTime(path).Equal(seen) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *lists) loadPlain(path string) error {
+	name := filepath.Base(path)
+	hosts := utils.NewHostList(nil)
+
+	err := readLines(path, func(line string) error {
+		if strings.Contains(line, "://") {
+			normalized, err := utils.NormalizeURL(line, false)
+			if err != nil {
+				return err
+			}
+			l.urls[normalized] = name
+			return nil
+		}
+
+		hosts.Add(line)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("loading blocklist %s: %w", path, err)
+	}
+
+	l.hosts = append(l.hosts, namedHostList{name: name, hosts: hosts})
+	return nil
+}
+
+func (l *lists) loadHashes(path string) error {
+	name := filepath.Base(path)
+
+	err := readLines(path, func(line string) error {
+		prefix, err := hex.DecodeString(line)
+		if err != nil || len(prefix) < 4 || len(prefix) > sha256.Size {
+			return fmt.Errorf("invalid hash prefix %q", line)
+		}
+
+		if l.prefixes[len(prefix)] == nil {
+			l.prefixes[len(prefix)] = make(map[string]string)
+		}
+		l.prefixes[len(prefix)][string(prefix)] = name
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("loading hash list %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// urlExpressions returns the host suffix / path prefix combinations that
+// Safe Browsing hashes for a URL: the exact host plus up to four suffixes
+// formed from its last five components, each combined with the exact path
+// with and without query and up to four leading path prefixes.
+func urlExpressions(u *url.URL) []string {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+
+	hosts := []string{host}
+	if net.ParseIP(host) == nil {
+		parts := strings.Split(host, ".")
+		start := len(parts) - 5
+		if start < 1 {
+			start = 1
+		}
+		for i := start; i < len(parts)-1; i++ {
+			hosts = append(hosts, strings.Join(parts[i:], "."))
+		}
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	paths := []string{path}
+	if u.RawQuery != "" {
+		paths = append([]string{path + "?" + u.RawQuery}, paths...)
+	}
+
+	prefix := "/"
+	if path != prefix {
+		paths = append(paths, prefix)
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i < len(segments)-1 && i < 3; i++ {
+		prefix += segments[i] + "/"
+		if prefix != path {
+			paths = append(paths, prefix)
+		}
+	}
+
+	expressions := make([]string, 0, len(hosts)*len(paths))
+	for _, h := range hosts {
+		for _, p := range paths {
+			expressions = append(expressions, h+p)
+		}
+	}
+	return expressions
+}
+
+func readLines(path string, fn func(line string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
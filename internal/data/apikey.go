@@ -0,0 +1,58 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// APIKey identifies a client and the owner its links belong to. Only the
+// SHA-256 hash of the key is stored; the plaintext is shown once on creation.
+type APIKey struct {
+	ID        int        `json:"id"`
+	OwnerID   string     `json:"owner_id"`
+	Name      string     `json:"name"`
+	KeyHash   string     `json:"-"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (k *APIKey) Insert(key APIKey) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into api_keys (owner_id, name, key_hash, created_at)
+		values ($1, $2, $3, $4) returning id`
+
+	err := db.QueryRowContext(ctx, stmt,
+		key.OwnerID,
+		key.Name,
+		key.KeyHash,
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetActiveByHash returns the non-revoked key with the given hash.
+func (k *APIKey) GetActiveByHash(hash string) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, owner_id, name, key_hash, created_at, revoked_at from api_keys
+		where key_hash = $1 and revoked_at is null`
+
+	var key APIKey
+
+	row := db.QueryRowContext(ctx, query, hash)
+	err := row.Scan(&key.ID, &key.OwnerID, &key.Name, &key.KeyHash, &key.CreatedAt, &key.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
@@ -0,0 +1,64 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// AuditEntry records an admin action.
+type AuditEntry struct {
+	ID         int       `json:"id"`
+	Actor      string    `json:"actor"`
+	Action     string    `json:"action"`
+	ShortCode  string    `json:"short_code,omitempty"`
+	Details    string    `json:"details,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (a *AuditEntry) Insert(entry AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into audit_log (actor, action, short_code, details, remote_addr, created_at)
+		values ($1, $2, $3, $4, $5, $6)`
+
+	_, err := db.ExecContext(ctx, stmt,
+		entry.Actor,
+		entry.Action,
+		nullString(entry.ShortCode),
+		entry.Details,
+		entry.RemoteAddr,
+		time.Now(),
+	)
+
+	return err
+}
+
+// List returns audit entries newest first, optionally filtered by code.
+func (a *AuditEntry) List(code string, limit, offset int) ([]AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, actor, action, coalesce(short_code, ''), details, remote_addr, created_at from audit_log
+		where ($1 = '' or short_code = $1)
+		order by created_at desc limit $2 offset $3`
+
+	rows, err := db.QueryContext(ctx, query, code, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		err := rows.Scan(&entry.ID, &entry.Actor, &entry.Action, &entry.ShortCode, &entry.Details, &entry.RemoteAddr, &entry.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
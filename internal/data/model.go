@@ -2,6 +2,7 @@ package data
 
 import (
 	"database/sql"
+	"github.com/hbrawnak/go-linko/internal/database"
 	"time"
 )
 
@@ -12,10 +13,45 @@ var db *sql.DB
 func New(dbPool *sql.DB) Models {
 	db = dbPool
 	return Models{
-		URL: URL{},
+		URL:           URL{},
+		APIKey:        APIKey{},
+		Report:        Report{},
+		AuditEntry:    AuditEntry{},
+		OwnerSettings: OwnerSettings{},
 	}
 }
 
 type Models struct {
-	URL URL
+	URL           URL
+	APIKey        APIKey
+	Report        Report
+	AuditEntry    AuditEntry
+	OwnerSettings OwnerSettings
+}
+
+// nullString stores empty strings as NULL so optional columns stay unset.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullInt64(n int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: n, Valid: n != 0}
+}
+
+// nullJSON encodes v for a JSONB column in the same format as the cache,
+// storing empty maps and slices as NULL.
+func nullJSON(v any) sql.NullString {
+	return nullString(database.JSONField(v))
+}
+
+// expectRow turns an update that matched nothing into sql.ErrNoRows.
+func expectRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
 }
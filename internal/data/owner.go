@@ -0,0 +1,46 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// OwnerSettings apply to every link of an owner, checked at redirect time.
+type OwnerSettings struct {
+	OwnerID string `json:"owner_id"`
+
+	// Interstitial shows a warning page before redirecting, for owners
+	// whose links are not trusted.
+	Interstitial bool      `json:"interstitial"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Get returns the settings of owner, or the defaults when none are stored.
+func (o *OwnerSettings) Get(owner string) (*OwnerSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select owner_id, interstitial, updated_at from owner_settings where owner_id = $1`
+
+	settings := OwnerSettings{OwnerID: owner}
+	err := db.QueryRowContext(ctx, query, owner).Scan(&settings.OwnerID, &settings.Interstitial, &settings.UpdatedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+// Upsert stores the settings of an owner.
+func (o *OwnerSettings) Upsert(settings OwnerSettings) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into owner_settings (owner_id, interstitial, updated_at) values ($1, $2, $3)
+		on conflict (owner_id) do update set interstitial = excluded.interstitial, updated_at = excluded.updated_at`
+
+	_, err := db.ExecContext(ctx, stmt, settings.OwnerID, settings.Interstitial, time.Now())
+	return err
+}
@@ -0,0 +1,89 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+// Report is a public complaint about a link. Reporters are identified only by
+// a fingerprint so the same person cannot report a link twice.
+type Report struct {
+	ID                  int        `json:"id"`
+	ShortCode           string     `json:"short_code"`
+	Reason              string     `json:"reason"`
+	Details             string     `json:"details,omitempty"`
+	ReporterFingerprint string     `json:"-"`
+	Status              string     `json:"status"`
+	CreatedAt           time.Time  `json:"created_at"`
+	ResolvedAt          *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Insert stores a report. It returns false without error when the reporter
+// already reported this link.
+func (rp *Report) Insert(report Report) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into reports (short_code, reason, details, reporter_fingerprint, status, created_at)
+		values ($1, $2, $3, $4, $5, $6)
+		on conflict (short_code, reporter_fingerprint) do nothing`
+
+	res, err := db.ExecContext(ctx, stmt,
+		report.ShortCode,
+		report.Reason,
+		report.Details,
+		report.ReporterFingerprint,
+		ReportOpen,
+		time.Now(),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// List returns reports newest first, optionally filtered by status and code.
+func (rp *Report) List(status, code string, limit, offset int) ([]Report, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, short_code, reason, details, status, created_at, resolved_at from reports
+		where ($1 = '' or status = $1) and ($2 = '' or short_code = $2)
+		order by created_at desc limit $3 offset $4`
+
+	rows, err := db.QueryContext(ctx, query, status, code, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		var report Report
+		err := rows.Scan(&report.ID, &report.ShortCode, &report.Reason, &report.Details, &report.Status, &report.CreatedAt, &report.ResolvedAt)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, rows.Err()
+}
+
+// ResolveForCode closes every open report of a link.
+func (rp *Report) ResolveForCode(code string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `update reports set status = $1, resolved_at = NOW() where short_code = $2 and status = $3`
+
+	_, err := db.ExecContext(ctx, query, ReportResolved, code, ReportOpen)
+	return err
+}
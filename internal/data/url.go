@@ -2,17 +2,141 @@ package data
 
 import (
 	"context"
+	"database/sql"
+	"github.com/hbrawnak/go-linko/internal/database"
+	"github.com/hbrawnak/go-linko/internal/metadata"
+	"github.com/hbrawnak/go-linko/internal/targeting"
 	"log"
 	"time"
 )
 
 type URL struct {
-	ID          int       `json:"id"`
-	ShortCode   string    `json:"short_code"`
-	OriginalURL string    `json:"original_url"`
-	HitCount    int64     `json:"hit_count"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID             int    `json:"id"`
+	ShortCode      string `json:"short_code"`
+	OriginalURL    string `json:"original_url"`
+	NormalizedHash string `json:"-"`
+	PasswordHash   string `json:"-"`
+	OwnerID        string `json:"owner_id,omitempty"`
+	HitCount       int64  `json:"hit_count"`
+	MaxClicks      int64  `json:"max_clicks,omitempty"`
+	ClicksUsed     int64  `json:"clicks_used"`
+	RedirectStatus int    `json:"redirect_status,omitempty"`
+	ForwardQuery   string `json:"forward_query,omitempty"`
+	ForwardPath    bool   `json:"forward_path,omitempty"`
+
+	// QueryParams are added to the destination at redirect time. Values may
+	// contain placeholders such as {code} and {date}.
+	QueryParams         map[string]string `json:"query_params,omitempty"`
+	QueryParamsOverride bool              `json:"query_params_override,omitempty"`
+
+	// Targets send visitors matching a rule elsewhere than OriginalURL.
+	Targets []targeting.Rule `json:"targets,omitempty"`
+
+	// Languages map language tags to the destination for visitors
+	// preferring them.
+	Languages map[string]string `json:"languages,omitempty"`
+
+	// Variants are weighted destinations the link rotates between instead
+	// of OriginalURL, assigning visitors as Rotation says.
+	Variants []targeting.Variant `json:"variants,omitempty"`
+	Rotation string              `json:"rotation,omitempty"`
+
+	// DeepLink opens a mobile app, with OriginalURL as the web fallback.
+	DeepLink *targeting.DeepLink `json:"deep_link,omitempty"`
+
+	// Interstitial shows a warning page before redirecting; nil follows the
+	// service default.
+	Interstitial *bool `json:"interstitial,omitempty"`
+
+	// OpenGraph replaces the destination's tags in previews of the link.
+	OpenGraph *metadata.OpenGraph `json:"open_graph,omitempty"`
+
+	// Metadata of the destination page, fetched after the link is stored.
+	Metadata *metadata.Metadata `json:"metadata,omitempty"`
+
+	ActiveFrom     *time.Time `json:"active_from,omitempty"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	FallbackURL    string     `json:"fallback_url,omitempty"`
+	DisabledAt     *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason string     `json:"disabled_reason,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// urlColumns is the select list matching scanURL.
+const urlColumns = `id, short_code, original_url, coalesce(normalized_hash, ''), coalesce(password_hash, ''),
+	coalesce(owner_id, ''), hit_count, coalesce(max_clicks, 0), clicks_used,
+	coalesce(redirect_status, 0), coalesce(forward_query, ''), forward_path,
+	coalesce(query_params::text, ''), query_params_override, coalesce(targets::text, ''), coalesce(languages::text, ''), coalesce(variants::text, ''), coalesce(rotation, ''), coalesce(deep_link::text, ''), interstitial, coalesce(open_graph::text, ''), coalesce(metadata::text, ''), active_from, expires_at, coalesce(fallback_url, ''), disabled_at, coalesce(disabled_reason, ''), created_at, updated_at`
+
+func scanURL(row *sql.Row) (*URL, error) {
+	var url URL
+	var queryParams, targets, languages, variants, deepLink, openGraph, meta string
+
+	err := row.Scan(
+		&url.ID,
+		&url.ShortCode,
+		&url.OriginalURL,
+		&url.NormalizedHash,
+		&url.PasswordHash,
+		&url.OwnerID,
+		&url.HitCount,
+		&url.MaxClicks,
+		&url.ClicksUsed,
+		&url.RedirectStatus,
+		&url.ForwardQuery,
+		&url.ForwardPath,
+		&queryParams,
+		&url.QueryParamsOverride,
+		&targets,
+		&languages,
+		&variants,
+		&url.Rotation,
+		&deepLink,
+		&url.Interstitial,
+		&openGraph,
+		&meta,
+		&url.ActiveFrom,
+		&url.ExpiresAt,
+		&url.FallbackURL,
+		&url.DisabledAt,
+		&url.DisabledReason,
+		&url.CreatedAt,
+		&url.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.DecodeJSONField(queryParams, &url.QueryParams); err != nil {
+		return nil, err
+	}
+
+	if err := database.DecodeJSONField(targets, &url.Targets); err != nil {
+		return nil, err
+	}
+
+	if err := database.DecodeJSONField(languages, &url.Languages); err != nil {
+		return nil, err
+	}
+
+	if err := database.DecodeJSONField(variants, &url.Variants); err != nil {
+		return nil, err
+	}
+
+	if err := database.DecodeJSONField(deepLink, &url.DeepLink); err != nil {
+		return nil, err
+	}
+
+	if err := database.DecodeJSONField(openGraph, &url.OpenGraph); err != nil {
+		return nil, err
+	}
+
+	if err := database.DecodeJSONField(meta, &url.Metadata); err != nil {
+		return nil, err
+	}
+
+	return &url, nil
 }
 
 func (u *URL) Insert(url URL) (int, error) {
@@ -20,12 +144,33 @@ func (u *URL) Insert(url URL) (int, error) {
 	defer cancel()
 
 	var newID int
-	stmt := `insert into urls (short_code, original_url, created_at, updated_at)
-		values ($1, $2, $3, $4) returning id`
+	stmt := `insert into urls (short_code, original_url, normalized_hash, password_hash, owner_id, max_clicks,
+		redirect_status, forward_query, forward_path, query_params, query_params_override, targets, languages,
+		variants, rotation, deep_link, interstitial, open_graph, active_from, expires_at, fallback_url, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23) returning id`
 
 	err := db.QueryRowContext(ctx, stmt,
 		url.ShortCode,
 		url.OriginalURL,
+		nullString(url.NormalizedHash),
+		nullString(url.PasswordHash),
+		nullString(url.OwnerID),
+		nullInt64(url.MaxClicks),
+		nullInt64(int64(url.RedirectStatus)),
+		nullString(url.ForwardQuery),
+		url.ForwardPath,
+		nullJSON(url.QueryParams),
+		url.QueryParamsOverride,
+		nullJSON(url.Targets),
+		nullJSON(url.Languages),
+		nullJSON(url.Variants),
+		nullString(url.Rotation),
+		nullJSON(url.DeepLink),
+		url.Interstitial,
+		nullJSON(url.OpenGraph),
+		url.ActiveFrom,
+		url.ExpiresAt,
+		nullString(url.FallbackURL),
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
@@ -37,23 +182,183 @@ func (u *URL) Insert(url URL) (int, error) {
 	return newID, nil
 }
 
-func (u *URL) GetOne(code string) (*URL, error) {
+// InsertMany stores all urls in a single transaction, so either every row is
+// written or none are.
+func (u *URL) InsertMany(urls []URL) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := "select id, short_code, original_url, hit_count, created_at, updated_at from urls where short_code = $1"
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	var url URL
+	stmt, err := tx.PrepareContext(ctx, `insert into urls (short_code, original_url, normalized_hash, password_hash, owner_id, max_clicks,
+		redirect_status, forward_query, forward_path, query_params, query_params_override, targets, languages,
+		variants, rotation, deep_link, interstitial, open_graph, active_from, expires_at, fallback_url, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for _, url := range urls {
+		if _, err := stmt.ExecContext(ctx, url.ShortCode, url.OriginalURL, nullString(url.NormalizedHash), nullString(url.PasswordHash), nullString(url.OwnerID), nullInt64(url.MaxClicks),
+			nullInt64(int64(url.RedirectStatus)), nullString(url.ForwardQuery), url.ForwardPath,
+			nullJSON(url.QueryParams), url.QueryParamsOverride, nullJSON(url.Targets), nullJSON(url.Languages),
+			nullJSON(url.Variants), nullString(url.Rotation), nullJSON(url.DeepLink), url.Interstitial, nullJSON(url.OpenGraph), url.ActiveFrom, url.ExpiresAt, nullString(url.FallbackURL), now, now); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (u *URL) GetOne(code string) (*URL, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
 
-	row := db.QueryRowContext(ctx, query, code)
-	err := row.Scan(&url.ID, &url.ShortCode, &url.OriginalURL, &url.HitCount, &url.CreatedAt, &url.UpdatedAt)
+	query := "select " + urlColumns + " from urls where short_code = $1"
 
+	url, err := scanURL(db.QueryRowContext(ctx, query, code))
 	if err != nil {
 		log.Printf("GetOne Query error: %s\n", err.Error())
 		return nil, err
 	}
 
-	return &url, nil
+	return url, nil
+}
+
+// GetByNormalizedHash returns the oldest plain link of owner whose
+// destination normalizes to hash: one without schedule, restrictions or
+// redirect options that has not been disabled.
+func (u *URL) GetByNormalizedHash(owner, hash string) (*URL, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := "select " + urlColumns + ` from urls
+		where owner_id = $1 and normalized_hash = $2 and disabled_at is null
+		and active_from is null and expires_at is null and password_hash is null and max_clicks is null
+		and redirect_status is null and forward_query is null and not forward_path and query_params is null
+		and targets is null and languages is null and variants is null and deep_link is null
+		and interstitial is null and open_graph is null
+		order by id limit 1`
+
+	return scanURL(db.QueryRowContext(ctx, query, owner, hash))
+}
+
+// SetDisabled disables or restores a link. It returns sql.ErrNoRows when the
+// code does not exist.
+func (u *URL) SetDisabled(code string, disabled bool, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `update urls set disabled_at = NULL, disabled_reason = NULL, updated_at = NOW() where short_code = $1`
+	args := []any{code}
+	if disabled {
+		query = `update urls set disabled_at = NOW(), disabled_reason = $2, updated_at = NOW() where short_code = $1`
+		args = append(args, reason)
+	}
+
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	return expectRow(res)
+}
+
+// SetMetadata stores the metadata of a link's destination. It returns
+// sql.ErrNoRows when the code does not exist.
+func (u *URL) SetMetadata(code string, md *metadata.Metadata) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, "update urls set metadata = $2 where short_code = $1", code, nullJSON(md))
+	if err != nil {
+		return err
+	}
+
+	return expectRow(res)
+}
+
+// Delete removes a link. It returns sql.ErrNoRows when the code does not exist.
+func (u *URL) Delete(code string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	res, err := db.ExecContext(ctx, "delete from urls where short_code = $1", code)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.ExecContext(ctx, "delete from link_variant_clicks where short_code = $1", code); err != nil {
+		return err
+	}
+
+	return expectRow(res)
+}
+
+// IsExpired reports whether the link has passed its expiry time.
+func (u *URL) IsExpired() bool {
+	return u.ExpiresAt != nil && !u.ExpiresAt.After(time.Now())
+}
+
+// IsDisabled reports whether an admin has disabled the link.
+func (u *URL) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
+
+// IsProtected reports whether the link requires a password.
+func (u *URL) IsProtected() bool {
+	return u.PasswordHash != ""
+}
+
+// ClicksRemaining returns how many more redirects a click-limited link allows.
+func (u *URL) ClicksRemaining() int64 {
+	return max(u.MaxClicks-u.ClicksUsed, 0)
+}
+
+// ConsumeClick records one click of a click-limited link and returns the
+// clicks left. It returns sql.ErrNoRows when the link is exhausted or does
+// not exist.
+func (u *URL) ConsumeClick(code string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE urls
+		SET clicks_used = clicks_used + 1
+		WHERE short_code = $1 AND clicks_used < max_clicks
+		RETURNING max_clicks - clicks_used
+	`
+
+	var remaining int64
+	err := db.QueryRowContext(ctx, query, code).Scan(&remaining)
+	if err != nil {
+		return 0, err
+	}
+
+	return remaining, nil
+}
+
+// SyncClicksUsed raises the clicks used of a link to used. Clicks made
+// before the link was persisted only reached the Redis counter, so the row
+// is brought up to date once it exists; it is never lowered.
+func (u *URL) SyncClicksUsed(code string, used int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `
+		UPDATE urls
+		SET clicks_used = LEAST(GREATEST(clicks_used, $2), max_clicks)
+		WHERE short_code = $1
+	`
+
+	_, err := db.ExecContext(ctx, query, code, used)
+	return err
 }
 
 func (u *URL) IncrementHitCount(c string) error {
@@ -77,3 +382,39 @@ func (u *URL) IncrementHitCount(c string) error {
 
 	return nil
 }
+
+// IncrementVariantClicks counts a click of a link that went to variant.
+func (u *URL) IncrementVariantClicks(code, variant string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into link_variant_clicks (short_code, variant, clicks) values ($1, $2, 1)
+		on conflict (short_code, variant) do update set clicks = link_variant_clicks.clicks + 1`
+
+	_, err := db.ExecContext(ctx, stmt, code, variant)
+	return err
+}
+
+// VariantClicks returns the clicks of a link per variant.
+func (u *URL) VariantClicks(code string) (map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, "select variant, clicks from link_variant_clicks where short_code = $1", code)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clicks := make(map[string]int64)
+	for rows.Next() {
+		var variant string
+		var n int64
+		if err := rows.Scan(&variant, &n); err != nil {
+			return nil, err
+		}
+		clicks[variant] = n
+	}
+
+	return clicks, rows.Err()
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// Results of ConsumeClick other than the remaining click count.
+const (
+	ClicksNotSeeded int64 = -2
+	ClicksExhausted int64 = -1
+)
+
+// consumeClickScript takes one click from the counter at KEYS[1] if any are
+// left. Running it as a script keeps the check and decrement atomic across
+// replicas.
+var consumeClickScript = redis.NewScript(`
+local remaining = tonumber(redis.call('GET', KEYS[1]))
+if remaining == nil then
+	return -2
+end
+if remaining <= 0 then
+	return -1
+end
+return redis.call('DECR', KEYS[1])
+`)
+
+// ConsumeClick takes one click from the counter at key and returns the
+// clicks left afterwards, ClicksExhausted when none were left or
+// ClicksNotSeeded when the counter does not exist.
+func (r *RedisClient) ConsumeClick(key string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return consumeClickScript.Run(ctx, r.client, []string{key}).Int64()
+}
+
+// ClicksKey is the key counting the clicks left on a click-limited link.
+func ClicksKey(code string) string {
+	return "clicks:" + code
+}
+
+// SeedClicks creates the counter at key unless another replica already did.
+func (r *RedisClient) SeedClicks(key string, remaining int64, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return r.client.SetNX(ctx, key, remaining, ttl).Err()
+}
@@ -2,9 +2,11 @@ package database
 
 import (
 	"context"
+	"encoding/json"
 	"github.com/redis/go-redis/v9"
 	"log"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -22,13 +24,235 @@ const defaultTTLRedis = 24 * time.Hour
 type CachedURL struct {
 	URL       string `json:"url"`
 	Persisted string `json:"persisted"`
+	ExpiresAt string `json:"expires_at"`
+	Disabled  string `json:"disabled"`
+
+	// PasswordHash is the bcrypt hash of the link password, if any.
+	PasswordHash string `json:"password_hash"`
+
+	// MaxClicks limits the number of redirects; the clicks left are counted
+	// separately under ClicksKey.
+	MaxClicks string `json:"max_clicks"`
+
+	// ActiveFrom is when a scheduled link starts redirecting, in RFC3339.
+	// Before then visitors are sent to FallbackURL when it is set.
+	ActiveFrom  string `json:"active_from"`
+	FallbackURL string `json:"fallback_url"`
+
+	// RedirectStatus is empty when the service default applies.
+	RedirectStatus string `json:"redirect_status"`
+
+	// ForwardQuery is the mode for passing the request's query string on to
+	// the destination; ForwardPath is "1" when extra path segments are.
+	ForwardQuery string `json:"forward_query"`
+	ForwardPath  string `json:"forward_path"`
+
+	// QueryParams is the JSON object of query parameter templates added at
+	// redirect time; QueryOverride is "1" when request values replace them.
+	QueryParams   string `json:"query_params"`
+	QueryOverride string `json:"query_override"`
+
+	// Targets is the JSON array of targeting rules, so targeted redirects
+	// need no database call.
+	Targets string `json:"targets"`
+
+	// Languages is the JSON object of destinations by language tag.
+	Languages string `json:"languages"`
+
+	// Variants is the JSON array of weighted destinations and Rotation how
+	// visitors are assigned one.
+	Variants string `json:"variants"`
+	Rotation string `json:"rotation"`
+
+	// DeepLink is the JSON object of the app and store URLs of a deep link.
+	DeepLink string `json:"deep_link"`
+
+	// Interstitial is "1" or "0" when the link overrides whether a warning
+	// page is shown, and empty when the service default applies.
+	Interstitial string `json:"interstitial"`
+
+	// OpenGraph is the JSON object of the tags shown to preview crawlers.
+	OpenGraph string `json:"open_graph"`
+
+	// OwnerID lets owner settings that apply to every link, such as a forced
+	// interstitial, be checked at redirect time.
+	OwnerID string `json:"owner_id"`
 }
 
 func (c CachedURL) ToMap() map[string]string {
 	return map[string]string{
-		"url":       c.URL,
-		"persisted": c.Persisted,
+		"url":             c.URL,
+		"persisted":       c.Persisted,
+		"expires_at":      c.ExpiresAt,
+		"disabled":        c.Disabled,
+		"password_hash":   c.PasswordHash,
+		"max_clicks":      c.MaxClicks,
+		"active_from":     c.ActiveFrom,
+		"fallback_url":    c.FallbackURL,
+		"redirect_status": c.RedirectStatus,
+		"forward_query":   c.ForwardQuery,
+		"forward_path":    c.ForwardPath,
+		"query_params":    c.QueryParams,
+		"query_override":  c.QueryOverride,
+		"targets":         c.Targets,
+		"languages":       c.Languages,
+		"variants":        c.Variants,
+		"rotation":        c.Rotation,
+		"deep_link":       c.DeepLink,
+		"interstitial":    c.Interstitial,
+		"open_graph":      c.OpenGraph,
+		"owner_id":        c.OwnerID,
+	}
+}
+
+// JSONField encodes structured link options for a CachedURL field. Empty
+// maps and slices are stored as "".
+func JSONField(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+
+	switch s := string(b); s {
+	case "null", "{}", "[]":
+		return ""
+	default:
+		return s
+	}
+}
+
+// BoolField encodes an optional flag for a CachedURL field as "1", "0" or
+// "" when it is unset.
+func BoolField(b *bool) string {
+	switch {
+	case b == nil:
+		return ""
+	case *b:
+		return "1"
+	default:
+		return "0"
+	}
+}
+
+// DecodeJSONField decodes a field written by JSONField, or a JSONB column
+// selected as text, into v.
+func DecodeJSONField(s string, v any) error {
+	if s == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(s), v)
+}
+
+// CachedURLFromMap rebuilds a CachedURL from the fields returned by HGetAll.
+func CachedURLFromMap(m map[string]string) CachedURL {
+	return CachedURL{
+		URL:            m["url"],
+		Persisted:      m["persisted"],
+		ExpiresAt:      m["expires_at"],
+		Disabled:       m["disabled"],
+		PasswordHash:   m["password_hash"],
+		MaxClicks:      m["max_clicks"],
+		ActiveFrom:     m["active_from"],
+		FallbackURL:    m["fallback_url"],
+		RedirectStatus: m["redirect_status"],
+		ForwardQuery:   m["forward_query"],
+		ForwardPath:    m["forward_path"],
+		QueryParams:    m["query_params"],
+		QueryOverride:  m["query_override"],
+		Targets:        m["targets"],
+		Languages:      m["languages"],
+		Variants:       m["variants"],
+		Rotation:       m["rotation"],
+		DeepLink:       m["deep_link"],
+		Interstitial:   m["interstitial"],
+		OpenGraph:      m["open_graph"],
+		OwnerID:        m["owner_id"],
+	}
+}
+
+// IsDisabled reports whether an admin has disabled the link.
+func (c CachedURL) IsDisabled() bool {
+	return c.Disabled == "1"
+}
+
+// IsProtected reports whether the link requires a password.
+func (c CachedURL) IsProtected() bool {
+	return c.PasswordHash != ""
+}
+
+// IsClickLimited reports whether the link stops after a number of clicks.
+func (c CachedURL) IsClickLimited() bool {
+	return c.MaxClicks != "" && c.MaxClicks != "0"
+}
+
+// IsExpired reports whether the cached link has passed its expiry time.
+func (c CachedURL) IsExpired() bool {
+	if c.ExpiresAt == "" {
+		return false
+	}
+
+	t, err := time.Parse(time.RFC3339, c.ExpiresAt)
+	return err == nil && !t.After(time.Now())
+}
+
+// Cacheable reports whether every visit is redirected the same way, with no
+// schedule, password, click limit, targeting, rotation, deep link, crawler
+// page or query parameter placeholder to check, so browsers may cache a
+// permanent redirect.
+func (c CachedURL) Cacheable() bool {
+	return c.ActiveFrom == "" && c.ExpiresAt == "" && !c.IsProtected() && !c.IsClickLimited() && c.Targets == "" &&
+		c.Languages == "" && c.Variants == "" && c.DeepLink == "" && c.OpenGraph == "" &&
+		!strings.Contains(c.QueryParams, "{date}") && !strings.Contains(c.QueryParams, "{code}")
+}
+
+// ActiveFromTime returns when a scheduled link opens, or nil when it has no
+// activation time.
+func (c CachedURL) ActiveFromTime() *time.Time {
+	if c.ActiveFrom == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, c.ActiveFrom)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// IsPending reports whether a scheduled link is not active yet.
+func (c CachedURL) IsPending() bool {
+	t := c.ActiveFromTime()
+	return t != nil && t.After(time.Now())
+}
+
+// LinkTTL returns the cache TTL for a link. Besides honouring the expiry,
+// the entry of a scheduled link ends when the link activates, so a cached
+// entry never spans the change of activation state.
+func LinkTTL(activeFrom, expiresAt *time.Time) time.Duration {
+	ttl := TTLUntil(expiresAt)
+	if activeFrom != nil {
+		if until := time.Until(*activeFrom); until > 0 && until < ttl {
+			ttl = until
+		}
 	}
+	return ttl
+}
+
+// TTLUntil returns the cache TTL for a link, capped so an expiring link
+// does not outlive its expiry in the cache.
+func TTLUntil(expiresAt *time.Time) time.Duration {
+	if expiresAt == nil {
+		return defaultTTLRedis
+	}
+
+	until := time.Until(*expiresAt)
+	if until <= 0 {
+		return time.Second
+	}
+	if until < defaultTTLRedis {
+		return until
+	}
+	return defaultTTLRedis
 }
 
 func ConnectToRedis() *RedisClient {
@@ -85,6 +309,29 @@ func (r *RedisClient) Set(key string, value string, ttl ...time.Duration) error
 	return r.client.Set(ctx, key, value, expire).Err()
 }
 
+// SetNX stores value only if key does not exist yet and reports whether it did.
+func (r *RedisClient) SetNX(key string, value string, ttl time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return r.client.SetNX(ctx, key, value, ttl).Result()
+}
+
+// SetKeepTTL overwrites key without changing its remaining TTL.
+func (r *RedisClient) SetKeepTTL(key string, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return r.client.SetArgs(ctx, key, value, redis.SetArgs{KeepTTL: true}).Err()
+}
+
+func (r *RedisClient) Del(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return r.client.Del(ctx, key).Err()
+}
+
 func (r *RedisClient) HSet(key string, values map[string]string, ttl ...time.Duration) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
@@ -119,6 +366,37 @@ func (r *RedisClient) HGet(key, field string) (string, error) {
 	return val, nil
 }
 
+func (r *RedisClient) HGetAll(key string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	val, err := r.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		log.Printf("[Redis:HGetAll] failed for key=%q: %v", key, err)
+		return nil, err
+	}
+
+	if len(val) == 0 {
+		return nil, redis.Nil
+	}
+
+	return val, nil
+}
+
+// HSetNX sets field only if it does not exist yet and reports whether it did.
+func (r *RedisClient) HSetNX(key, field, value string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	ok, err := r.client.HSetNX(ctx, key, field, value).Result()
+	if err != nil {
+		log.Printf("[Redis:HSetNX] failed for key=%q field=%q: %v", key, field, err)
+		return false, err
+	}
+
+	return ok, nil
+}
+
 func (r *RedisClient) INCR() int64 {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
@@ -131,3 +409,19 @@ func (r *RedisClient) INCR() int64 {
 	log.Println("Successfully incr url_counter", incr)
 	return incr
 }
+
+// INCRBY advances the url counter by n and returns the new value, reserving
+// the n values ending at it for the caller.
+func (r *RedisClient) INCRBY(n int64) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	incr, err := r.client.IncrBy(ctx, IncrKey, n).Result()
+	if err != nil {
+		log.Println("Failed to incrby url_counter", err)
+		return 0, err
+	}
+
+	log.Println("Successfully incrby url_counter", incr)
+	return incr, nil
+}
@@ -0,0 +1,118 @@
+package geoip
+
+import (
+	"github.com/oschwald/maxminddb-golang"
+	"net"
+	"sync"
+	"time"
+)
+
+// DB looks up client locations in a local MaxMind DB file such as GeoLite2
+// Country or City. A nil *DB is valid and finds nothing, so geo targeting is
+// simply inactive when no database is configured.
+type DB struct {
+	path string
+
+	mu       sync.RWMutex
+	reader   *maxminddb.Reader
+	loadedAt time.Time
+}
+
+// Location is the country and, with a City database, the first-level
+// region of an address, as ISO 3166 codes such as "US" and "US-CA".
+type Location struct {
+	Country string
+	Region  string
+}
+
+// Info describes the loaded database.
+type Info struct {
+	Path         string    `json:"path"`
+	DatabaseType string    `json:"database_type"`
+	BuildTime    time.Time `json:"build_time"`
+	IPVersion    uint      `json:"ip_version"`
+	NodeCount    uint      `json:"node_count"`
+	LoadedAt     time.Time `json:"loaded_at"`
+}
+
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"subdivisions"`
+}
+
+// Open loads the database at path. An empty path returns a nil *DB.
+func Open(path string) (*DB, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	d := &DB{path: path}
+	if err := d.Reload(); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Reload reads the database file again, for example after it was updated.
+// On error the previous database stays in use.
+func (d *DB) Reload() error {
+	reader, err := maxminddb.Open(d.path)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	old := d.reader
+	d.reader = reader
+	d.loadedAt = time.Now()
+	d.mu.Unlock()
+
+	// No lookup can still be using the old reader once the lock was taken
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// Lookup returns the location of ip, or an empty Location when it is unknown.
+func (d *DB) Lookup(ip string) Location {
+	parsed := net.ParseIP(ip)
+	if d == nil || parsed == nil {
+		return Location{}
+	}
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var rec record
+	if err := d.reader.Lookup(parsed, &rec); err != nil {
+		return Location{}
+	}
+
+	loc := Location{Country: rec.Country.ISOCode}
+	if len(rec.Subdivisions) > 0 && loc.Country != "" && rec.Subdivisions[0].ISOCode != "" {
+		loc.Region = loc.Country + "-" + rec.Subdivisions[0].ISOCode
+	}
+
+	return loc
+}
+
+func (d *DB) Info() Info {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	meta := d.reader.Metadata
+	return Info{
+		Path:         d.path,
+		DatabaseType: meta.DatabaseType,
+		BuildTime:    time.Unix(int64(meta.BuildEpoch), 0).UTC(),
+		IPVersion:    meta.IPVersion,
+		NodeCount:    meta.NodeCount,
+		LoadedAt:     d.loadedAt,
+	}
+}
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/hbrawnak/go-linko/internal/data"
+	"github.com/hbrawnak/go-linko/internal/service"
+	"github.com/hbrawnak/go-linko/internal/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const ownerIDMaxLen = 64
+
+// actorMaxLen is the length of the actor column of the audit log.
+const actorMaxLen = 255
+
+const defaultPageSize = 50
+const maxPageSize = 200
+
+// LinkActionRequest is the optional body of admin link actions.
+type LinkActionRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	OwnerID string `json:"owner_id"`
+	Name    string `json:"name"`
+}
+
+type OwnerSettingsRequest struct {
+	Interstitial bool `json:"interstitial"`
+}
+
+// HandleCreateAPIKey issues a new api key. The plaintext key is only returned
+// in this response.
+func (app *AppHandler) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+
+	if err := app.Response.ReadJSON(w, r, &req); err != nil {
+		app.Response.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if req.OwnerID == "" || len(req.OwnerID) > ownerIDMaxLen {
+		app.Response.ErrorJSON(w, errors.New("owner_id is required and must be at most 64 characters"), http.StatusBadRequest)
+		return
+	}
+
+	plaintext, key, err := app.Service.CreateAPIKey(req.OwnerID, req.Name)
+	if err != nil {
+		log.Printf("failed to create api key: %v", err)
+		app.Response.ErrorJSON(w, errors.New("failed to create api key"), http.StatusInternalServerError)
+		return
+	}
+
+	entry := app.adminActor(r)
+	entry.Action = service.AuditAPIKeyCreate
+	entry.Details = "owner: " + key.OwnerID
+	app.Service.Audit(entry)
+
+	payload := utils.JsonResponse{
+		Error:   false,
+		Message: "API Key Created",
+		Data: map[string]any{
+			"id":       key.ID,
+			"owner_id": key.OwnerID,
+			"name":     key.Name,
+			"key":      plaintext,
+		},
+	}
+
+	_ = app.Response.WriteJSON(w, http.StatusCreated, payload)
+}
+
+func (app *AppHandler) HandleListReports(w http.ResponseWriter, r *http.Request) {
+	limit, offset := pagination(r)
+
+	reports, err := app.Service.Models.Report.List(r.URL.Query().Get("status"), r.URL.Query().Get("code"), limit, offset)
+	if err != nil {
+		log.Printf("failed to list reports: %v", err)
+		app.Response.ErrorJSON(w, errors.New("failed to list reports"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := utils.JsonResponse{
+		Error:   false,
+		Message: "Reports",
+		Data:    reports,
+	}
+
+	_ = app.Response.WriteJSON(w, http.StatusOK, payload)
+}
+
+func (app *AppHandler) HandleListAudit(w http.ResponseWriter, r *http.Request) {
+	limit, offset := pagination(r)
+
+	entries, err := app.Service.Models.AuditEntry.List(r.URL.Query().Get("code"), limit, offset)
+	if err != nil {
+		log.Printf("failed to list audit log: %v", err)
+		app.Response.ErrorJSON(w, errors.New("failed to list audit log"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := utils.JsonResponse{
+		Error:   false,
+		Message: "Audit Log",
+		Data:    entries,
+	}
+
+	_ = app.Response.WriteJSON(w, http.StatusOK, payload)
+}
+
+func (app *AppHandler) HandleDisableLink(w http.ResponseWriter, r *http.Request) {
+	app.handleLinkAction(w, r, "Link Disabled", func(code, reason string, actor data.AuditEntry) error {
+		return app.Service.SetLinkDisabled(code, true, reason, actor)
+	})
+}
+
+func (app *AppHandler) HandleRestoreLink(w http.ResponseWriter, r *http.Request) {
+	app.handleLinkAction(w, r, "Link Restored", func(code, reason string, actor data.AuditEntry) error {
+		return app.Service.SetLinkDisabled(code, false, reason, actor)
+	})
+}
+
+func (app *AppHandler) HandleDeleteLink(w http.ResponseWriter, r *http.Request) {
+	app.handleLinkAction(w, r, "Link Deleted", app.Service.DeleteLink)
+}
+
+// handleLinkAction runs an audited admin action on the link in the URL.
+func (app *AppHandler) handleLinkAction(w http.ResponseWriter, r *http.Request, message string, action func(code, reason string, actor data.AuditEntry) error) {
+	code := chi.URLParam(r, "code")
+
+	// Validating short code
+	if err := utils.ValidateShortCode(code); err != nil {
+		app.Response.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var req LinkActionRequest
+	if r.ContentLength != 0 {
+		if err := app.Response.ReadJSON(w, r, &req); err != nil {
+			app.Response.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := action(code, req.Reason, app.adminActor(r)); err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			app.Response.ErrorJSON(w, errors.New("no result found"), http.StatusNotFound)
+			return
+		}
+		log.Printf("admin action on %s failed: %v", code, err)
+		app.Response.ErrorJSON(w, errors.New("admin action failed"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := utils.JsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    map[string]string{"code": code},
+	}
+
+	_ = app.Response.WriteJSON(w, http.StatusOK, payload)
+}
+
+// adminActor identifies who performed an admin action. All admins share the
+// ADMIN_TOKEN, so they can name themselves with the X-Admin-Actor header.
+// The name is cleaned and cut to fit the audit log, since an audit entry
+// that fails to insert would leave the action unrecorded.
+func (app *AppHandler) adminActor(r *http.Request) data.AuditEntry {
+	actor := strings.Map(func(c rune) rune {
+		if unicode.IsControl(c) {
+			return -1
+		}
+		return c
+	}, strings.ToValidUTF8(r.Header.Get("X-Admin-Actor"), ""))
+
+	actor = strings.TrimSpace(actor)
+	if runes := []rune(actor); len(runes) > actorMaxLen {
+		actor = string(runes[:actorMaxLen])
+	}
+	if actor == "" {
+		actor = "admin"
+	}
+
+	return data.AuditEntry{
+		Actor:      actor,
+		RemoteAddr: app.ClientIP.ClientIP(r),
+	}
+}
+
+func pagination(r *http.Request) (int, int) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	return limit, offset
+}
+
+func (app *AppHandler) HandleGeoIPInfo(w http.ResponseWriter, r *http.Request) {
+	if app.Service.GeoIP == nil {
+		app.Response.ErrorJSON(w, errors.New("no geoip database is configured"), http.StatusNotFound)
+		return
+	}
+
+	payload := utils.JsonResponse{
+		Error:   false,
+		Message: "GeoIP Database",
+		Data:    app.Service.GeoIP.Info(),
+	}
+
+	_ = app.Response.WriteJSON(w, http.StatusOK, payload)
+}
+
+// HandleGeoIPReload reloads the GeoIP database from disk, so an updated file
+// is picked up without a restart.
+func (app *AppHandler) HandleGeoIPReload(w http.ResponseWriter, r *http.Request) {
+	if app.Service.GeoIP == nil {
+		app.Response.ErrorJSON(w, errors.New("no geoip database is configured"), http.StatusNotFound)
+		return
+	}
+
+	if err := app.Service.GeoIP.Reload(); err != nil {
+		log.Printf("failed to reload geoip database: %v", err)
+		app.Response.ErrorJSON(w, errors.New("failed to reload geoip database"), http.StatusInternalServerError)
+		return
+	}
+
+	info := app.Service.GeoIP.Info()
+
+	entry := app.adminActor(r)
+	entry.Action = service.AuditGeoIPReload
+	entry.Details = fmt.Sprintf("%s built %s", info.DatabaseType, info.BuildTime.Format(time.RFC3339))
+	app.Service.Audit(entry)
+
+	payload := utils.JsonResponse{
+		Error:   false,
+		Message: "GeoIP Database Reloaded",
+		Data:    info,
+	}
+
+	_ = app.Response.WriteJSON(w, http.StatusOK, payload)
+}
+
+func (app *AppHandler) HandleGetOwnerSettings(w http.ResponseWriter, r *http.Request) {
+	owner := chi.URLParam(r, "owner")
+
+	settings, err := app.Service.Models.OwnerSettings.Get(owner)
+	if err != nil {
+		log.Printf("failed to load settings of owner %s: %v", owner, err)
+		app.Response.ErrorJSON(w, errors.New("failed to load owner settings"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := utils.JsonResponse{
+		Error:   false,
+		Message: "Owner Settings",
+		Data:    settings,
+	}
+
+	_ = app.Response.WriteJSON(w, http.StatusOK, payload)
+}
+
+// HandleUpdateOwnerSettings changes the settings applied to every link of an
+// owner, existing ones included, for example to put links of an untrusted
+// owner behind an interstitial.
+func (app *AppHandler) HandleUpdateOwnerSettings(w http.ResponseWriter, r *http.Request) {
+	owner := chi.URLParam(r, "owner")
+	if len(owner) > ownerIDMaxLen {
+		app.Response.ErrorJSON(w, errors.New("owner_id must be at most 64 characters"), http.StatusBadRequest)
+		return
+	}
+
+	var req OwnerSettingsRequest
+	if err := app.Response.ReadJSON(w, r, &req); err != nil {
+		app.Response.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if err := app.Service.SetOwnerInterstitial(owner, req.Interstitial, app.adminActor(r)); err != nil {
+		log.Printf("failed to update settings of owner %s: %v", owner, err)
+		app.Response.ErrorJSON(w, errors.New("failed to update owner settings"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := utils.JsonResponse{
+		Error:   false,
+		Message: "Owner Settings Updated",
+		Data: data.OwnerSettings{
+			OwnerID:      owner,
+			Interstitial: req.Interstitial,
+			UpdatedAt:    time.Now(),
+		},
+	}
+
+	_ = app.Response.WriteJSON(w, http.StatusOK, payload)
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"github.com/hbrawnak/go-linko/internal/service"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const ownerContextKey contextKey = "owner"
+
+// RequireAPIKey resolves the `Authorization: Bearer <key>` header to the key's
+// owner and stores it in the request context. Requests without a valid key
+// are rejected with 401, and with 503 when the key cannot be looked up.
+func (app *AppHandler) RequireAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.Response.ErrorJSON(w, errors.New("api key is required"), http.StatusUnauthorized)
+			return
+		}
+
+		owner, err := app.Service.ResolveAPIKey(token)
+		if errors.Is(err, service.ErrInvalidAPIKey) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.Response.ErrorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			// The lookup failed, which says nothing about the key; the
+			// service has logged the cause
+			app.Response.ErrorJSON(w, errors.New("unable to check api key, try again later"), http.StatusServiceUnavailable)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ownerContextKey, owner)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireAdmin guards admin endpoints with the ADMIN_TOKEN bearer token.
+// Admin endpoints are disabled when no token is configured.
+func (app *AppHandler) RequireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.AdminToken == "" {
+			app.Response.ErrorJSON(w, errors.New("admin api is disabled"), http.StatusForbidden)
+			return
+		}
+
+		token, ok := bearerToken(r)
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(app.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.Response.ErrorJSON(w, errors.New("invalid admin token"), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ownerFromContext returns the owner resolved by RequireAPIKey, or an empty
+// string for unauthenticated requests.
+func ownerFromContext(r *http.Request) string {
+	owner, _ := r.Context().Value(ownerContextKey).(string)
+	return owner
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
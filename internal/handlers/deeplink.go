@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hbrawnak/go-linko/internal/database"
+	"github.com/hbrawnak/go-linko/internal/pages"
+	"github.com/hbrawnak/go-linko/internal/targeting"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+)
+
+// AppAssociations holds the files that tie apps to the short domain. Either
+// may be empty when no app of that platform is configured.
+type AppAssociations struct {
+	AppleAppSiteAssociation []byte
+	AssetLinks              []byte
+}
+
+// LoadAppAssociationsFromEnv reads the files named by
+// APPLE_APP_SITE_ASSOCIATION_FILE and ANDROID_ASSET_LINKS_FILE.
+func LoadAppAssociationsFromEnv() *AppAssociations {
+	aasa, err := readJSONFile(os.Getenv("APPLE_APP_SITE_ASSOCIATION_FILE"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	assetLinks, err := readJSONFile(os.Getenv("ANDROID_ASSET_LINKS_FILE"))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return &AppAssociations{
+		AppleAppSiteAssociation: aasa,
+		AssetLinks:              assetLinks,
+	}
+}
+
+// readJSONFile returns the contents of the JSON file at path, or nil when
+// path is empty.
+func readJSONFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(b) {
+		return nil, fmt.Errorf("%s is not valid JSON", path)
+	}
+
+	return b, nil
+}
+
+func (app *AppHandler) HandleAppleAppSiteAssociation(w http.ResponseWriter, r *http.Request) {
+	app.serveAssociation(w, app.AppAssociations.AppleAppSiteAssociation)
+}
+
+func (app *AppHandler) HandleAssetLinks(w http.ResponseWriter, r *http.Request) {
+	app.serveAssociation(w, app.AppAssociations.AssetLinks)
+}
+
+func (app *AppHandler) serveAssociation(w http.ResponseWriter, body []byte) {
+	if len(body) == 0 {
+		app.Response.ErrorJSON(w, errors.New("not found"), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// appLaunch is how a visitor's device opens the app of a deep link: AppURL
+// first, then Fallback when the app does not open.
+type appLaunch struct {
+	AppURL   template.URL
+	Fallback string
+}
+
+// launchApp returns how the visitor of r opens the app of link, or nil when
+// the link has no deep link or the visitor is not on iOS or Android.
+func launchApp(r *http.Request, link *database.CachedURL, target string) *appLaunch {
+	if link.DeepLink == "" {
+		return nil
+	}
+
+	var deepLink targeting.DeepLink
+	if err := database.DecodeJSONField(link.DeepLink, &deepLink); err != nil {
+		log.Printf("invalid deep link, using the web URL: %v", err)
+		return nil
+	}
+
+	ua := targeting.ParseUserAgent(r.UserAgent())
+	if !deepLink.OpensApp(ua) {
+		return nil
+	}
+
+	fallback := deepLink.StoreURL(ua.OS)
+	if fallback == "" {
+		fallback = target
+	}
+
+	return &appLaunch{
+		// The scheme was checked when the link was created; without
+		// template.URL it would be replaced as unsafe.
+		AppURL:   template.URL(deepLink.AppURL),
+		Fallback: fallback,
+	}
+}
+
+// serveDeepLink answers for a deep link. Visitors on iOS and Android get a
+// page that opens the app and, when that does not happen, continues to the
+// app's store page or target. Everyone else is redirected to target.
+func (app *AppHandler) serveDeepLink(w http.ResponseWriter, r *http.Request, link *database.CachedURL, target string) {
+	launch := launchApp(r, link, target)
+	if launch == nil {
+		app.redirect(w, r, link, target)
+		return
+	}
+
+	_ = pages.Render(w, http.StatusOK, "deeplink", map[string]any{
+		"AppURL":   launch.AppURL,
+		"Fallback": launch.Fallback,
+		"WebURL":   target,
+	})
+}
@@ -4,18 +4,172 @@ import (
 	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
-	"github.com/hbrawnak/go-linko/internal/data"
 	"github.com/hbrawnak/go-linko/internal/database"
+	"github.com/hbrawnak/go-linko/internal/metadata"
+	"github.com/hbrawnak/go-linko/internal/pages"
 	"github.com/hbrawnak/go-linko/internal/service"
+	"github.com/hbrawnak/go-linko/internal/targeting"
 	"github.com/hbrawnak/go-linko/internal/utils"
 	"github.com/hbrawnak/go-linko/internal/worker"
+	"log"
+	"math"
 	"net/http"
 	"os"
-	"sync"
+	"strconv"
+	"time"
 )
 
+const defaultBatchMaxSize = 100
+
+const codeDestinationBlocked = "destination_blocked"
+
+// codeInternal reports a batch item that failed on the server's side.
+const codeInternal = "internal"
+
+// maxClicksLimit is the largest max_clicks the database column can hold.
+const maxClicksLimit = math.MaxInt32
+
+var errAliasTaken = errors.New("alias is already taken")
+
 type ShortenRequest struct {
-	URL string `json:"url"`
+	URL       string `json:"url"`
+	Alias     string `json:"alias,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+
+	// ActiveFrom schedules the link to start redirecting at a later time.
+	// Until then visitors are sent to FallbackURL, or shown a "not yet
+	// available" page when it is empty.
+	ActiveFrom  string `json:"active_from,omitempty"`
+	FallbackURL string `json:"fallback_url,omitempty"`
+
+	// Password, when set, must be entered before the link redirects.
+	Password string `json:"password,omitempty"`
+
+	// MaxClicks deactivates the link after that many redirects.
+	MaxClicks int64 `json:"max_clicks,omitempty"`
+
+	// RedirectStatus is one of 301, 302, 307 or 308; the service default is
+	// used when it is omitted.
+	RedirectStatus int `json:"redirect_status,omitempty"`
+
+	// ForwardQuery passes the query string of the short link request on to
+	// the destination: "append", "keep" or "replace" decide which values win
+	// for keys present on both. ForwardPath appends extra path segments, so
+	// /{code}/a/b redirects to the destination path plus /a/b.
+	ForwardQuery string `json:"forward_query,omitempty"`
+	ForwardPath  bool   `json:"forward_path,omitempty"`
+
+	// QueryParams are added to the destination on every redirect unless it
+	// already has them. Values may use the {code} and {date} placeholders.
+	// UTM is shorthand for the utm_* parameters. With QueryParamsOverride,
+	// values from the short link request replace the stored ones.
+	QueryParams         map[string]string `json:"query_params,omitempty"`
+	UTM                 *UTMParams        `json:"utm,omitempty"`
+	QueryParamsOverride bool              `json:"query_params_override,omitempty"`
+
+	// Targets send visitors elsewhere by operating system, device class or
+	// browser. The first matching rule wins; URL is the default.
+	Targets []targeting.Rule `json:"targets,omitempty"`
+
+	// Languages map language tags such as "de" or "fr-CA" to destinations,
+	// negotiated against the visitor's Accept-Language header when no
+	// target matches.
+	Languages map[string]string `json:"languages,omitempty"`
+
+	// Variants rotate visitors between weighted destinations in place of
+	// URL, for A/B tests. Rotation is "random" (the default) to pick on
+	// every visit, or "cookie" or "ip" to keep a visitor on one variant.
+	Variants []targeting.Variant `json:"variants,omitempty"`
+	Rotation string              `json:"rotation,omitempty"`
+
+	// DeepLink opens an app on iOS and Android, falling back to its store
+	// page and then to URL.
+	DeepLink *targeting.DeepLink `json:"deep_link,omitempty"`
+
+	// Interstitial shows a "you are leaving" page before redirecting, or
+	// turns it off; the service default applies when it is omitted. Links of
+	// owners an admin has marked as untrusted always show it. On deep links
+	// it opens the app once the visitor continues.
+	Interstitial *bool `json:"interstitial,omitempty"`
+
+	// OpenGraph sets the title, description and image that chat apps and
+	// social networks show when the link is shared. Their crawlers get a
+	// page with these tags instead of the redirect.
+	OpenGraph *metadata.OpenGraph `json:"open_graph,omitempty"`
+
+	// ReuseExisting returns the code of an existing plain link with an
+	// equivalent destination instead of creating a new one. It is ignored
+	// when an alias or any link option is requested.
+	ReuseExisting bool `json:"reuse_existing,omitempty"`
+}
+
+type UTMParams struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+// queryParams merges the utm shorthand into the query parameter templates.
+// Explicit query_params take precedence.
+func (req ShortenRequest) queryParams() map[string]string {
+	if req.UTM == nil {
+		return req.QueryParams
+	}
+
+	params := make(map[string]string)
+	for key, value := range map[string]string{
+		"utm_source":   req.UTM.Source,
+		"utm_medium":   req.UTM.Medium,
+		"utm_campaign": req.UTM.Campaign,
+		"utm_term":     req.UTM.Term,
+		"utm_content":  req.UTM.Content,
+	} {
+		if value != "" {
+			params[key] = value
+		}
+	}
+	for key, value := range req.QueryParams {
+		params[key] = value
+	}
+
+	return params
+}
+
+// isPlain reports whether the requested link only redirects to its URL,
+// without schedule, restrictions or redirect options, which makes it
+// interchangeable with links to an equivalent destination.
+func (req ShortenRequest) isPlain() bool {
+	return req.ActiveFrom == "" && req.ExpiresAt == "" && req.Password == "" && req.MaxClicks == 0 &&
+		req.RedirectStatus == 0 && req.ForwardQuery == "" && !req.ForwardPath && len(req.queryParams()) == 0 &&
+		len(req.Targets) == 0 && len(req.Languages) == 0 &&
+		len(req.Variants) == 0 && req.DeepLink == nil && req.Interstitial == nil &&
+		req.OpenGraph == nil
+}
+
+func (req ShortenRequest) canReuse() bool {
+	return req.ReuseExisting && req.Alias == "" && req.isPlain()
+}
+
+// linkSchedule is the parsed activation window of a shorten request.
+type linkSchedule struct {
+	ActiveFrom *time.Time
+	ExpiresAt  *time.Time
+}
+
+// BatchItemResult reports the outcome of a single item of a batch request.
+// Index refers to the item's position in the request array.
+type BatchItemResult struct {
+	Index     int    `json:"index"`
+	URL       string `json:"url"`
+	ShortURL  string `json:"short_url,omitempty"`
+	Code      string `json:"code,omitempty"`
+	Reused    bool   `json:"reused,omitempty"`
+	Warning   string `json:"warning,omitempty"`
+	Error     bool   `json:"error"`
+	ErrorCode string `json:"error_code,omitempty"`
+	Message   string `json:"message,omitempty"`
 }
 
 type StatsDataResp struct {
@@ -24,16 +178,44 @@ type StatsDataResp struct {
 }
 
 type AppHandler struct {
-	Service      *service.Service
-	Response     *utils.Response
-	URLTaskQueue chan worker.URLTask
+	Service           *service.Service
+	Response          *utils.Response
+	URLTaskQueue      chan worker.URLTask
+	URLBatchTaskQueue chan []worker.URLTask
+	BatchMaxSize      int
+	IdempotencyTTL    time.Duration
+	AdminToken        string
+	RateLimits        map[string]RateLimit
+	ClientIP          *utils.ClientIPResolver
+	Unlock            *UnlockSigner
+
+	// RedirectStatus is used by links created without a status.
+	RedirectStatus      int
+	RedirectCacheMaxAge time.Duration
+
+	// AppAssociations are served from /.well-known so the short domain can
+	// open apps as universal links and app links.
+	AppAssociations *AppAssociations
+
+	Interstitial *InterstitialConfig
 }
 
-func NewHandler(service *service.Service, queue chan worker.URLTask) *AppHandler {
+func NewHandler(service *service.Service, queue chan worker.URLTask, batchQueue chan []worker.URLTask) *AppHandler {
 	return &AppHandler{
-		Service:      service,
-		Response:     &utils.Response{},
-		URLTaskQueue: queue,
+		Service:             service,
+		Response:            &utils.Response{},
+		URLTaskQueue:        queue,
+		URLBatchTaskQueue:   batchQueue,
+		BatchMaxSize:        utils.GetEnvInt("BATCH_MAX_SIZE", defaultBatchMaxSize),
+		IdempotencyTTL:      utils.GetEnvDuration("IDEMPOTENCY_TTL", defaultIdempotencyTTL),
+		AdminToken:          os.Getenv("ADMIN_TOKEN"),
+		RateLimits:          loadRateLimits(),
+		ClientIP:            utils.NewClientIPResolver(os.Getenv("TRUSTED_PROXIES")),
+		Unlock:              NewUnlockSignerFromEnv(),
+		RedirectStatus:      loadRedirectStatus(),
+		RedirectCacheMaxAge: utils.GetEnvDuration("REDIRECT_CACHE_MAX_AGE", defaultRedirectCacheMaxAge),
+		AppAssociations:     LoadAppAssociationsFromEnv(),
+		Interstitial:        LoadInterstitialConfigFromEnv(),
 	}
 }
 
@@ -55,43 +237,92 @@ func (app *AppHandler) HandleShorten(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := utils.ValidateOriginalURL(req.URL); err != nil {
+	schedule, err := app.validateShortenRequest(&req, ownerFromContext(r))
+	if err != nil {
+		app.Response.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	hash, err := app.Service.NormalizedHash(req.URL)
+	if err != nil {
 		app.Response.ErrorJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
-	code := app.Service.GenerateShortCode()
+	owner := ownerFromContext(r)
 
-	// 1. save data in db
-	u := data.URL{
-		ShortCode:   code,
-		OriginalURL: req.URL,
+	if req.canReuse() {
+		if code, ok := app.Service.FindExistingCode(owner, hash); ok {
+			payload := utils.JsonResponse{
+				Error:   false,
+				Message: "Existing URL Reused",
+				Data: map[string]string{
+					"short_url": fmt.Sprintf("%s/%s", baseUrl, code),
+					"code":      code,
+				},
+			}
+			_ = app.Response.WriteJSON(w, http.StatusOK, payload)
+			return
+		}
 	}
 
-	fields := database.CachedURL{
-		URL:       req.URL,
-		Persisted: "0",
+	passwordHash, err := app.Service.HashLinkPassword(req.Password)
+	if err != nil {
+		log.Printf("failed to hash link password: %v", err)
+		app.Response.ErrorJSON(w, errors.New("failed to hash password"), http.StatusInternalServerError)
+		return
 	}
 
-	// storing cache using wait group
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		_ = app.Service.Redis.HSet(u.ShortCode, fields.ToMap())
-	}()
+	code := req.Alias
+	if code == "" {
+		code = app.Service.GenerateShortCode()
+	}
 
-	wg.Wait()
+	task := worker.URLTask{
+		ShortCode:      code,
+		OriginalURL:    req.URL,
+		NormalizedHash: hash,
+		PasswordHash:   passwordHash,
+		OwnerID:        owner,
+		MaxClicks:      req.MaxClicks,
+		RedirectStatus: req.RedirectStatus,
+		ForwardQuery:   req.ForwardQuery,
+		ForwardPath:    req.ForwardPath,
+		QueryParams:    req.queryParams(),
+		QueryOverride:  req.QueryParamsOverride,
+		Targets:        req.Targets,
+		Languages:      req.Languages,
+		Variants:       req.Variants,
+		Rotation:       req.Rotation,
+		DeepLink:       req.DeepLink,
+		Interstitial:   req.Interstitial,
+		OpenGraph:      req.OpenGraph,
+		ActiveFrom:     schedule.ActiveFrom,
+		ExpiresAt:      schedule.ExpiresAt,
+		FallbackURL:    req.FallbackURL,
+	}
 
-	// Sending to worker queue to make db operations
-	app.URLTaskQueue <- worker.URLTask{
-		ShortCode:   u.ShortCode,
-		OriginalURL: u.OriginalURL,
+	// 1. store in cache so the link resolves before it is persisted
+	if err := app.cacheNewLink(task, req.Alias != ""); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errAliasTaken) {
+			status = http.StatusConflict
+		}
+		app.Response.ErrorJSON(w, err, status)
+		return
 	}
 
+	app.indexNewLink(task)
+
+	// Sending to worker queue to make db operations
+	app.URLTaskQueue <- task
+
 	shortUrlResp := map[string]string{
-		"short_url": fmt.Sprintf("%s/%s", baseUrl, u.ShortCode),
-		"code":      u.ShortCode,
+		"short_url": fmt.Sprintf("%s/%s", baseUrl, task.ShortCode),
+		"code":      task.ShortCode,
+	}
+	if warning := app.redirectWarning(task.RedirectStatus); warning != "" {
+		shortUrlResp["warning"] = warning
 	}
 
 	// 2 return response
@@ -103,6 +334,359 @@ func (app *AppHandler) HandleShorten(w http.ResponseWriter, r *http.Request) {
 	_ = app.Response.WriteJSON(w, http.StatusOK, payload)
 }
 
+// HandleShortenBatch shortens an array of ShortenRequest items. Items are
+// validated independently, so an invalid item is reported in its result
+// without failing the rest of the batch.
+func (app *AppHandler) HandleShortenBatch(w http.ResponseWriter, r *http.Request) {
+	var baseUrl = os.Getenv("BASE_URL")
+	var items []ShortenRequest
+
+	if err := app.Response.ReadJSON(w, r, &items); err != nil {
+		app.Response.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if len(items) == 0 {
+		app.Response.ErrorJSON(w, errors.New("batch must contain at least one item"), http.StatusBadRequest)
+		return
+	}
+
+	if len(items) > app.BatchMaxSize {
+		app.Response.ErrorJSON(w, fmt.Errorf("batch exceeds maximum of %d items", app.BatchMaxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	owner := ownerFromContext(r)
+	results := make([]BatchItemResult, len(items))
+	schedules := make([]linkSchedule, len(items))
+	hashes := make([]string, len(items))
+	// duplicateOf maps a reuse_existing item to an earlier item in the same
+	// batch with an equivalent destination.
+	duplicateOf := make(map[int]int)
+	firstByHash := make(map[string]int)
+	needCodes := 0
+
+	for i, item := range items {
+		results[i] = BatchItemResult{Index: i, URL: item.URL}
+
+		schedule, err := app.validateShortenRequest(&items[i], owner)
+		if err == nil {
+			hashes[i], err = app.Service.NormalizedHash(items[i].URL)
+		}
+		if err != nil {
+			results[i].Error = true
+			results[i].ErrorCode = utils.ErrorCodeOf(err)
+			results[i].Message = err.Error()
+			continue
+		}
+		schedules[i] = schedule
+
+		if item.canReuse() {
+			if code, ok := app.Service.FindExistingCode(owner, hashes[i]); ok {
+				results[i].Code = code
+				results[i].ShortURL = fmt.Sprintf("%s/%s", baseUrl, code)
+				results[i].Reused = true
+				continue
+			}
+			if j, ok := firstByHash[hashes[i]]; ok {
+				duplicateOf[i] = j
+				continue
+			}
+		}
+
+		if item.isPlain() {
+			if _, ok := firstByHash[hashes[i]]; !ok {
+				firstByHash[hashes[i]] = i
+			}
+		}
+		if item.Alias == "" {
+			needCodes++
+		}
+	}
+
+	// Generate codes for every item without an alias in one pass
+	codes, err := app.Service.GenerateShortCodes(needCodes)
+	if err != nil {
+		app.Response.ErrorJSON(w, errors.New("failed to generate short codes"), http.StatusInternalServerError)
+		return
+	}
+
+	tasks := make([]worker.URLTask, 0, len(items))
+	for i, item := range items {
+		if _, ok := duplicateOf[i]; ok || results[i].Error || results[i].Reused {
+			continue
+		}
+
+		code := item.Alias
+		if code == "" {
+			code, codes = codes[0], codes[1:]
+		}
+
+		task := worker.URLTask{
+			ShortCode:      code,
+			OriginalURL:    item.URL,
+			NormalizedHash: hashes[i],
+			OwnerID:        owner,
+			MaxClicks:      item.MaxClicks,
+			RedirectStatus: item.RedirectStatus,
+			ForwardQuery:   item.ForwardQuery,
+			ForwardPath:    item.ForwardPath,
+			QueryParams:    item.queryParams(),
+			QueryOverride:  item.QueryParamsOverride,
+			Targets:        item.Targets,
+			Languages:      item.Languages,
+			Variants:       item.Variants,
+			Rotation:       item.Rotation,
+			DeepLink:       item.DeepLink,
+			Interstitial:   item.Interstitial,
+			OpenGraph:      item.OpenGraph,
+			ActiveFrom:     schedules[i].ActiveFrom,
+			ExpiresAt:      schedules[i].ExpiresAt,
+			FallbackURL:    item.FallbackURL,
+		}
+
+		task.PasswordHash, err = app.Service.HashLinkPassword(item.Password)
+		if err != nil {
+			log.Printf("failed to hash link password: %v", err)
+			results[i].Error = true
+			results[i].ErrorCode = codeInternal
+			results[i].Message = "failed to hash password"
+			continue
+		}
+
+		if err := app.cacheNewLink(task, item.Alias != ""); err != nil {
+			results[i].Error = true
+			results[i].ErrorCode = utils.ErrorCodeOf(err)
+			results[i].Message = err.Error()
+			continue
+		}
+
+		app.indexNewLink(task)
+		results[i].Code = code
+		results[i].ShortURL = fmt.Sprintf("%s/%s", baseUrl, code)
+		results[i].Warning = app.redirectWarning(task.RedirectStatus)
+		tasks = append(tasks, task)
+	}
+
+	for i, j := range duplicateOf {
+		results[i].Code = results[j].Code
+		results[i].ShortURL = results[j].ShortURL
+		results[i].Reused = true
+		results[i].Error = results[j].Error
+		results[i].ErrorCode = results[j].ErrorCode
+		results[i].Message = results[j].Message
+	}
+
+	// Sending the whole batch to the worker to persist in one transaction
+	if len(tasks) > 0 {
+		app.URLBatchTaskQueue <- tasks
+	}
+
+	payload := utils.JsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d of %d URLs Shortened", len(tasks), len(items)),
+		Data:    results,
+	}
+
+	_ = app.Response.WriteJSON(w, http.StatusOK, payload)
+}
+
+// validateShortenRequest checks a single shorten item of owner and returns
+// its parsed schedule. Destinations that are short links resolved by the
+// chain policy, in req.URL or any of its options, are replaced by their
+// targets.
+func (app *AppHandler) validateShortenRequest(req *ShortenRequest, owner string) (linkSchedule, error) {
+	var schedule linkSchedule
+
+	var err error
+	if req.URL, err = app.checkExtraDestination(req.URL, owner); err != nil {
+		return schedule, err
+	}
+
+	if req.Alias != "" {
+		if err := utils.ValidateAlias(req.Alias); err != nil {
+			return schedule, err
+		}
+	}
+
+	if req.Password != "" {
+		if err := utils.ValidateLinkPassword(req.Password); err != nil {
+			return schedule, err
+		}
+	}
+
+	if req.MaxClicks < 0 || req.MaxClicks > maxClicksLimit {
+		return schedule, fmt.Errorf("max_clicks must be between 1 and %d, or 0 for no limit", maxClicksLimit)
+	}
+
+	if err := validateRedirectStatus(req.RedirectStatus); err != nil {
+		return schedule, err
+	}
+
+	if err := utils.ValidateForwardQuery(req.ForwardQuery); err != nil {
+		return schedule, err
+	}
+
+	if err := utils.ValidateQueryParams(req.queryParams()); err != nil {
+		return schedule, err
+	}
+
+	// The validators of the link options only check their own fields. Every
+	// destination they hold is checked here like req.URL, by
+	// checkExtraDestination
+	if err := targeting.Validate(req.Targets); err != nil {
+		return schedule, err
+	}
+	if targeting.UsesLocation(req.Targets) && app.Service.GeoIP == nil {
+		return schedule, errors.New("country and region targets require a GeoIP database, which is not configured")
+	}
+	for i := range req.Targets {
+		if req.Targets[i].URL, err = app.checkExtraDestination(req.Targets[i].URL, owner); err != nil {
+			return schedule, err
+		}
+	}
+
+	if err := targeting.ValidateLanguages(req.Languages); err != nil {
+		return schedule, err
+	}
+	for tag, dest := range req.Languages {
+		if req.Languages[tag], err = app.checkExtraDestination(dest, owner); err != nil {
+			return schedule, err
+		}
+	}
+
+	targeting.SetVariantDefaults(req.Variants)
+	if err := targeting.ValidateVariants(req.Variants, req.Rotation); err != nil {
+		return schedule, err
+	}
+	if len(req.Variants) > 0 && len(req.Languages) > 0 {
+		return schedule, errors.New("variants cannot be combined with languages")
+	}
+	for i := range req.Variants {
+		if req.Variants[i].URL, err = app.checkExtraDestination(req.Variants[i].URL, owner); err != nil {
+			return schedule, err
+		}
+	}
+
+	if req.DeepLink != nil {
+		if err := req.DeepLink.Validate(); err != nil {
+			return schedule, err
+		}
+		for _, store := range []*string{&req.DeepLink.IOSStoreURL, &req.DeepLink.AndroidStoreURL} {
+			if *store == "" {
+				continue
+			}
+			if *store, err = app.checkExtraDestination(*store, owner); err != nil {
+				return schedule, err
+			}
+		}
+	}
+
+	if req.OpenGraph != nil {
+		if err := req.OpenGraph.Validate(); err != nil {
+			return schedule, err
+		}
+		if req.OpenGraph.Image != "" {
+			if err := utils.ValidateOriginalURL(req.OpenGraph.Image); err != nil {
+				return schedule, err
+			}
+		}
+	}
+
+	if schedule.ExpiresAt, err = utils.ParseExpiry(req.ExpiresAt); err != nil {
+		return schedule, err
+	}
+
+	if schedule.ActiveFrom, err = utils.ParseActiveFrom(req.ActiveFrom); err != nil {
+		return schedule, err
+	}
+
+	if schedule.ActiveFrom != nil && schedule.ExpiresAt != nil && !schedule.ActiveFrom.Before(*schedule.ExpiresAt) {
+		return schedule, errors.New("active_from must be before expires_at")
+	}
+
+	if req.FallbackURL != "" {
+		if schedule.ActiveFrom == nil {
+			return schedule, errors.New("fallback_url requires active_from")
+		}
+		if req.FallbackURL, err = app.checkExtraDestination(req.FallbackURL, owner); err != nil {
+			return schedule, err
+		}
+	}
+
+	return schedule, nil
+}
+
+// checkExtraDestination checks a URL the link may send visitors to against
+// the URL policy, the chain policy and the network and blocklist checks. It
+// returns the URL to store, which is the target when a short link was
+// resolved.
+func (app *AppHandler) checkExtraDestination(u, owner string) (string, error) {
+	if err := utils.ValidateOriginalURL(u); err != nil {
+		return "", err
+	}
+
+	dest, err := app.Service.ResolveChain(u)
+	if err != nil {
+		return "", err
+	}
+
+	if err := app.checkDestination(dest, owner); err != nil {
+		return "", err
+	}
+
+	return dest, nil
+}
+
+// checkDestination applies the network and blocklist checks to a URL the
+// link may send visitors to.
+func (app *AppHandler) checkDestination(u, owner string) error {
+	if err := app.Service.Network.CheckURL(u, owner); err != nil {
+		return err
+	}
+
+	if _, blocked := app.Service.Blocklist.Match(u); blocked {
+		return utils.NewPolicyError(codeDestinationBlocked, "url is on a blocklist of harmful sites")
+	}
+
+	return nil
+}
+
+// cacheNewLink writes a not-yet-persisted link to the cache. Custom aliases
+// are reserved atomically and fail with errAliasTaken if already in use.
+func (app *AppHandler) cacheNewLink(task worker.URLTask, isAlias bool) error {
+	fields := task.CachedFields()
+	fields.Persisted = "0"
+	ttl := database.LinkTTL(task.ActiveFrom, task.ExpiresAt)
+
+	if isAlias {
+		ok, err := app.Service.ReserveCode(task.ShortCode, fields, ttl)
+		if err != nil {
+			log.Printf("failed to reserve alias %s: %v", task.ShortCode, err)
+			return errors.New("failed to reserve alias")
+		}
+		if !ok {
+			return errAliasTaken
+		}
+	} else if err := app.Service.Redis.HSet(task.ShortCode, fields.ToMap(), ttl); err != nil {
+		log.Printf("failed to cache %s: %v", task.ShortCode, err)
+	}
+
+	if task.MaxClicks > 0 {
+		app.Service.InitClicks(task.ShortCode, task.MaxClicks, database.TTLUntil(task.ExpiresAt))
+	}
+
+	return nil
+}
+
+// indexNewLink makes a freshly created link discoverable by reuse_existing.
+// Only plain links are reused, so others are not indexed.
+func (app *AppHandler) indexNewLink(task worker.URLTask) {
+	if task.Plain() && task.NormalizedHash != "" {
+		app.Service.IndexNormalizedHash(task.OwnerID, task.NormalizedHash, task.ShortCode)
+	}
+}
+
 func (app *AppHandler) HandleRedirect(w http.ResponseWriter, r *http.Request) {
 	code := chi.URLParam(r, "code")
 
@@ -112,30 +696,85 @@ func (app *AppHandler) HandleRedirect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if originalUrl, err := app.Service.Redis.HGet(code, "url"); err == nil {
-		// Update hit count
-		app.Service.UpdateHitCountBG(code)
-		http.Redirect(w, r, originalUrl, http.StatusFound)
+	link, err := app.Service.ResolveLink(code)
+	if err != nil {
+		app.Response.ErrorJSON(w, errors.New("no result found"), http.StatusNotFound)
+		return
+	}
+
+	if link.IsDisabled() {
+		_ = pages.Render(w, http.StatusGone, "unavailable", map[string]string{"Code": code})
+		return
+	}
+
+	if link.IsExpired() {
+		app.Response.ErrorJSON(w, errors.New("link has expired"), http.StatusGone)
+		return
+	}
+
+	if link.IsPending() {
+		app.servePendingLink(w, r, code, link)
 		return
 	}
 
-	shortenedUrl, err := app.Service.Models.URL.GetOne(code)
+	target, variant, err := app.destination(w, r, code, link)
 	if err != nil {
 		app.Response.ErrorJSON(w, errors.New("no result found"), http.StatusNotFound)
 		return
 	}
 
-	fields := database.CachedURL{
-		URL:       shortenedUrl.OriginalURL,
-		Persisted: "1",
+	// Destinations can turn bad after the link was created
+	if list, blocked := app.Service.Blocklist.Match(target); blocked {
+		log.Printf("blocked redirect of %s: destination is on %s", code, list)
+		_ = pages.Render(w, http.StatusForbidden, "blocked", map[string]string{"Code": code})
+		return
+	}
+
+	if link.IsProtected() && !app.Unlock.Verify(r, code, link) {
+		_ = pages.Render(w, http.StatusUnauthorized, "password", map[string]string{"Code": code})
+		return
+	}
+
+	if link.IsClickLimited() && !app.Service.HasClicksLeft(code, link) {
+		app.Response.ErrorJSON(w, service.ErrClicksExhausted, http.StatusGone)
+		return
+	}
+
+	// Crawlers building a preview are not visitors, so they do not use up
+	// clicks or count as hits. Their page does not reveal the destination.
+	if link.OpenGraph != "" && targeting.IsUnfurler(r.UserAgent()) {
+		app.serveOpenGraph(w, code, link)
+		return
+	}
+
+	if link.IsClickLimited() {
+		if _, err := app.Service.ConsumeClick(code, link); err != nil {
+			if errors.Is(err, service.ErrClicksExhausted) {
+				app.Response.ErrorJSON(w, err, http.StatusGone)
+				return
+			}
+			log.Printf("failed to count click of %s: %v", code, err)
+			app.Response.ErrorJSON(w, errors.New("link is temporarily unavailable"), http.StatusServiceUnavailable)
+			return
+		}
 	}
-	// storing cache in background
-	app.Service.StoreInRedisCacheBG(shortenedUrl.ShortCode, fields.ToMap())
 
 	// Update hit count
-	app.Service.UpdateHitCountBG(shortenedUrl.ShortCode)
+	app.Service.UpdateHitCountBG(code, variant)
+
+	// The interstitial comes first, since the deep link page and its
+	// fallbacks leave the site too. It opens the app itself.
+	if app.showsInterstitial(link, target) {
+		app.serveInterstitial(w, r, code, target, launchApp(r, link, target))
+		return
+	}
+
+	if link.DeepLink != "" {
+		app.serveDeepLink(w, r, link, target)
+		return
+	}
 
-	http.Redirect(w, r, shortenedUrl.OriginalURL, http.StatusFound)
+	app.redirect(w, r, link, target)
 }
 
 func (app *AppHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
@@ -147,7 +786,7 @@ func (app *AppHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	stats, err := app.Service.GetStats(code)
+	stats, err := app.Service.GetStats(code, ownerFromContext(r))
 	if err != nil {
 		app.Response.ErrorJSON(w, err, http.StatusNotFound)
 		return
@@ -161,3 +800,32 @@ func (app *AppHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
 
 	_ = app.Response.WriteJSON(w, http.StatusOK, payload)
 }
+
+// servePendingLink answers for a scheduled link that is not active yet,
+// sending visitors to its fallback URL or a "not yet available" page.
+func (app *AppHandler) servePendingLink(w http.ResponseWriter, r *http.Request, code string, link *database.CachedURL) {
+	if link.FallbackURL != "" {
+		if list, blocked := app.Service.Blocklist.Match(link.FallbackURL); blocked {
+			log.Printf("blocked fallback redirect of %s: destination is on %s", code, list)
+			_ = pages.Render(w, http.StatusForbidden, "blocked", map[string]string{"Code": code})
+			return
+		}
+
+		if app.showsInterstitial(link, link.FallbackURL) {
+			app.serveInterstitial(w, r, code, link.FallbackURL, nil)
+			return
+		}
+
+		// The link redirects elsewhere once active, so this is never cached
+		w.Header().Set("Cache-Control", "private, no-store")
+		http.Redirect(w, r, link.FallbackURL, http.StatusFound)
+		return
+	}
+
+	activeFrom := link.ActiveFromTime()
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(time.Until(*activeFrom))))
+	_ = pages.Render(w, http.StatusNotFound, "pending", map[string]any{
+		"Code":       code,
+		"ActiveFrom": activeFrom,
+	})
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	idempotencyHeader       = "Idempotency-Key"
+	idempotencyKeyMaxLen    = 255
+	defaultIdempotencyTTL   = 24 * time.Hour
+	idempotencyMaxBodyBytes = 10485760
+)
+
+// idempotencyRecord is stored in Redis under the client's key. Status is zero
+// while the original request is still being processed.
+type idempotencyRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// responseRecorder passes the response through to the client while keeping
+// a copy so it can be replayed for retries.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// Idempotent honors the Idempotency-Key header. The first request with a key
+// is processed normally and its response stored for IdempotencyTTL; retries
+// with the same key and body get the stored response replayed, while a
+// different body under the same key is rejected with 422.
+func (app *AppHandler) Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > idempotencyKeyMaxLen {
+			app.Response.ErrorJSON(w, errors.New("Idempotency-Key is too long"), http.StatusBadRequest)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, idempotencyMaxBodyBytes))
+		if err != nil {
+			app.Response.ErrorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := requestFingerprint(r, body)
+		cacheKey := idempotencyKey(r, key)
+
+		pending, _ := json.Marshal(idempotencyRecord{Fingerprint: fingerprint})
+		claimed, err := app.Service.Redis.SetNX(cacheKey, string(pending), app.IdempotencyTTL)
+		if err != nil {
+			log.Printf("idempotency: failed to claim key: %v", err)
+			app.Response.ErrorJSON(w, errors.New("unable to process Idempotency-Key"), http.StatusServiceUnavailable)
+			return
+		}
+
+		if !claimed {
+			app.replayIdempotent(w, cacheKey, fingerprint)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// Server errors are not stored so the client can retry with the same key
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			if err := app.Service.Redis.Del(cacheKey); err != nil {
+				log.Printf("idempotency: failed to release key: %v", err)
+			}
+			return
+		}
+
+		stored, _ := json.Marshal(idempotencyRecord{
+			Fingerprint: fingerprint,
+			Status:      rec.status,
+			ContentType: rec.Header().Get("Content-Type"),
+			Body:        rec.body.Bytes(),
+		})
+		if err := app.Service.Redis.SetKeepTTL(cacheKey, string(stored)); err != nil {
+			log.Printf("idempotency: failed to store response: %v", err)
+		}
+	})
+}
+
+func (app *AppHandler) replayIdempotent(w http.ResponseWriter, cacheKey, fingerprint string) {
+	cached, err := app.Service.Redis.Get(cacheKey)
+	if err != nil {
+		app.Response.ErrorJSON(w, errors.New("unable to process Idempotency-Key"), http.StatusServiceUnavailable)
+		return
+	}
+
+	var record idempotencyRecord
+	if err := json.Unmarshal([]byte(cached), &record); err != nil {
+		app.Response.ErrorJSON(w, errors.New("unable to process Idempotency-Key"), http.StatusServiceUnavailable)
+		return
+	}
+
+	if record.Fingerprint != fingerprint {
+		app.Response.ErrorJSON(w, errors.New("Idempotency-Key was already used with a different request"), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if record.Status == 0 {
+		app.Response.ErrorJSON(w, errors.New("a request with this Idempotency-Key is still in progress"), http.StatusConflict)
+		return
+	}
+
+	if record.ContentType != "" {
+		w.Header().Set("Content-Type", record.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.Status)
+	_, _ = w.Write(record.Body)
+}
+
+// requestFingerprint identifies the request a key was first used with.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyKey scopes keys per owner so clients cannot replay each other's responses.
+func idempotencyKey(r *http.Request, key string) string {
+	return "idem:" + ownerFromContext(r) + ":" + r.URL.Path + ":" + key
+}
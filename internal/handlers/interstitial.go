@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"github.com/hbrawnak/go-linko/internal/database"
+	"github.com/hbrawnak/go-linko/internal/pages"
+	"github.com/hbrawnak/go-linko/internal/utils"
+	"net/http"
+	"net/url"
+)
+
+// InterstitialConfig decides when visitors see a "you are leaving" page
+// instead of being redirected right away.
+type InterstitialConfig struct {
+	// Default applies to links that neither enable nor disable it.
+	Default bool
+
+	// Countdown is the number of seconds after which the page continues to
+	// the destination by itself; zero waits for the visitor.
+	Countdown int
+
+	// Allowed destinations never get an interstitial.
+	Allowed *utils.HostList
+}
+
+// LoadInterstitialConfigFromEnv reads INTERSTITIAL,
+// INTERSTITIAL_COUNTDOWN and INTERSTITIAL_ALLOWED_DOMAINS.
+func LoadInterstitialConfigFromEnv() *InterstitialConfig {
+	return &InterstitialConfig{
+		Default:   utils.GetEnvBool("INTERSTITIAL"),
+		Countdown: max(utils.GetEnvInt("INTERSTITIAL_COUNTDOWN", 0), 0),
+		Allowed:   utils.NewHostList(utils.SplitList(utils.GetEnv("INTERSTITIAL_ALLOWED_DOMAINS", ""))),
+	}
+}
+
+// Shows reports whether a visitor of link is shown the interstitial before
+// going on to target. Links of untrusted owners show it whatever the link
+// says.
+func (c *InterstitialConfig) Shows(link *database.CachedURL, target string, untrusted bool) bool {
+	switch {
+	case untrusted:
+	case link.Interstitial == "0":
+		return false
+	case link.Interstitial == "" && !c.Default:
+		return false
+	}
+
+	u, err := url.Parse(target)
+	return err != nil || !c.Allowed.Contains(u.Hostname())
+}
+
+// showsInterstitial reports whether the visitor of link is shown the
+// interstitial before going on to target, taking the owner's setting into
+// account.
+func (app *AppHandler) showsInterstitial(link *database.CachedURL, target string) bool {
+	return app.Interstitial.Shows(link, target, app.Service.OwnerInterstitial(link.OwnerID))
+}
+
+// serveInterstitial tells the visitor they are leaving the short domain for
+// target and lets them continue, automatically when a countdown is set. With
+// launch, continuing opens the app of a deep link the way the deep link page
+// does, and target stays available for the browser.
+func (app *AppHandler) serveInterstitial(w http.ResponseWriter, r *http.Request, code, target string, launch *appLaunch) {
+	to := target
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		to = u.Hostname()
+	}
+
+	page := map[string]any{
+		"Code":      code,
+		"From":      r.Host,
+		"To":        to,
+		"Target":    target,
+		"Countdown": app.Interstitial.Countdown,
+	}
+	if launch != nil {
+		page["AppURL"] = launch.AppURL
+		page["Fallback"] = launch.Fallback
+	}
+
+	_ = pages.Render(w, http.StatusOK, "interstitial", page)
+}
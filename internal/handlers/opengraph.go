@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/hbrawnak/go-linko/internal/database"
+	"github.com/hbrawnak/go-linko/internal/metadata"
+	"github.com/hbrawnak/go-linko/internal/pages"
+	"log"
+	"net/http"
+	"os"
+)
+
+// serveOpenGraph answers a link preview crawler with the Open Graph tags
+// the owner chose for the link, so the shared card shows them rather than
+// the destination's. The page links back to the short link rather than to
+// the destination, which would bypass click limits and the interstitial.
+func (app *AppHandler) serveOpenGraph(w http.ResponseWriter, code string, link *database.CachedURL) {
+	var og metadata.OpenGraph
+	if err := database.DecodeJSONField(link.OpenGraph, &og); err != nil {
+		log.Printf("invalid open graph tags of %s: %v", code, err)
+	}
+
+	_ = pages.Render(w, http.StatusOK, "opengraph", map[string]any{
+		"Code":        code,
+		"ShortURL":    fmt.Sprintf("%s/%s", os.Getenv("BASE_URL"), code),
+		"Title":       og.Title,
+		"Description": og.Description,
+		"Image":       og.Image,
+	})
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/hbrawnak/go-linko/internal/database"
+	"github.com/hbrawnak/go-linko/internal/pages"
+	"github.com/hbrawnak/go-linko/internal/utils"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultUnlockTTL = time.Hour
+const unlockFormMaxBytes = 4096
+const unlockCookiePrefix = "linko_unlock_"
+
+// UnlockSigner issues and checks the signed cookies that remember a visitor
+// has entered the password of a protected link.
+type UnlockSigner struct {
+	Secret []byte
+	TTL    time.Duration
+	Secure bool
+}
+
+// NewUnlockSignerFromEnv signs cookies with LINK_UNLOCK_SECRET. Without it a
+// random secret is used, so cookies are lost on restart and are not shared
+// between replicas.
+func NewUnlockSignerFromEnv() *UnlockSigner {
+	secret := []byte(os.Getenv("LINK_UNLOCK_SECRET"))
+	if len(secret) == 0 {
+		log.Println("LINK_UNLOCK_SECRET is not set, using a random secret for unlock cookies")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Panic(err)
+		}
+	}
+
+	return &UnlockSigner{
+		Secret: secret,
+		TTL:    utils.GetEnvDuration("LINK_UNLOCK_TTL", defaultUnlockTTL),
+		Secure: strings.HasPrefix(os.Getenv("BASE_URL"), "https://"),
+	}
+}
+
+// Issue sets a cookie unlocking code until the signer's TTL elapses.
+func (s *UnlockSigner) Issue(w http.ResponseWriter, code string, link *database.CachedURL) {
+	expires := time.Now().Add(s.TTL)
+	value := strconv.FormatInt(expires.Unix(), 10)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookiePrefix + code,
+		Value:    value + "." + s.sign(code, value, link),
+		Path:     "/" + code,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   s.Secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Verify reports whether r carries a valid, unexpired unlock cookie for code.
+// The signature covers the password hash, so cookies stop working when the
+// link is replaced.
+func (s *UnlockSigner) Verify(r *http.Request, code string, link *database.CachedURL) bool {
+	cookie, err := r.Cookie(unlockCookiePrefix + code)
+	if err != nil {
+		return false
+	}
+
+	value, sig, found := strings.Cut(cookie.Value, ".")
+	if !found {
+		return false
+	}
+
+	expires, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || time.Now().Unix() >= expires {
+		return false
+	}
+
+	return hmac.Equal([]byte(sig), []byte(s.sign(code, value, link)))
+}
+
+func (s *UnlockSigner) sign(code, expires string, link *database.CachedURL) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(code + "|" + expires + "|" + link.PasswordHash))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// HandleUnlock checks the password posted from the prompt of a protected
+// link. On success it sets an unlock cookie and sends the visitor back to the
+// page they came from, which now redirects.
+func (app *AppHandler) HandleUnlock(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+
+	// Validating short code
+	if err := utils.ValidateShortCode(code); err != nil {
+		app.Response.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	link, err := app.Service.ResolveLink(code)
+	if err != nil {
+		app.Response.ErrorJSON(w, errors.New("no result found"), http.StatusNotFound)
+		return
+	}
+
+	if !link.IsProtected() {
+		http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, unlockFormMaxBytes)
+	if !app.Service.CheckLinkPassword(link, r.PostFormValue("password")) {
+		_ = pages.Render(w, http.StatusUnauthorized, "password", map[string]string{
+			"Code":  code,
+			"Error": "Incorrect password, please try again.",
+		})
+		return
+	}
+
+	app.Unlock.Issue(w, code, link)
+	http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+}
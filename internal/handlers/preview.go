@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/hbrawnak/go-linko/internal/pages"
+	"github.com/hbrawnak/go-linko/internal/utils"
+	"net/http"
+)
+
+// HandlePreview shows where a link goes without following it, so it does
+// not count as a click. Destinations of password-protected and
+// click-limited links are not revealed, since seeing them would bypass the
+// password or the limit.
+func (app *AppHandler) HandlePreview(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+
+	if err := utils.ValidateShortCode(code); err != nil {
+		app.Response.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	link, err := app.Service.ResolveLink(code)
+	if err != nil {
+		app.Response.ErrorJSON(w, errors.New("no result found"), http.StatusNotFound)
+		return
+	}
+
+	if link.IsDisabled() {
+		_ = pages.Render(w, http.StatusGone, "unavailable", map[string]string{"Code": code})
+		return
+	}
+
+	if link.IsExpired() {
+		app.Response.ErrorJSON(w, errors.New("link has expired"), http.StatusGone)
+		return
+	}
+
+	if link.IsPending() {
+		_ = pages.Render(w, http.StatusNotFound, "pending", map[string]any{
+			"Code":       code,
+			"ActiveFrom": link.ActiveFromTime(),
+		})
+		return
+	}
+
+	if _, blocked := app.Service.Blocklist.Match(link.URL); blocked {
+		_ = pages.Render(w, http.StatusForbidden, "blocked", map[string]string{"Code": code})
+		return
+	}
+
+	page := map[string]any{
+		"Code":      code,
+		"Protected": link.IsProtected(),
+		"Varies":    link.Targets != "" || link.Languages != "" || link.Variants != "" || link.DeepLink != "",
+	}
+	hidden := link.IsProtected() || link.IsClickLimited()
+	if !hidden {
+		page["Destination"] = link.URL
+	}
+
+	// Links are only in the cache until the worker has persisted them
+	if stats, err := app.Service.GetPreview(code); err == nil {
+		page["CreatedAt"] = stats.CreatedAt
+		page["Count"] = stats.Count
+		if stats.Metadata != nil && !hidden {
+			page["Title"] = stats.Metadata.Title
+			page["FaviconURL"] = stats.Metadata.FaviconURL
+		}
+	}
+
+	_ = pages.Render(w, http.StatusOK, "preview", page)
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimit allows Limit requests per Window for each client. A zero Limit
+// disables limiting.
+type RateLimit struct {
+	Limit  int64
+	Window time.Duration
+}
+
+var defaultRateLimits = map[string]RateLimit{
+	"shorten":  {Limit: 60, Window: time.Minute},
+	"redirect": {Limit: 600, Window: time.Minute},
+	"stats":    {Limit: 120, Window: time.Minute},
+	"report":   {Limit: 10, Window: time.Hour},
+	"unlock":   {Limit: 5, Window: time.Minute},
+
+	// auth limits requests to key-protected routes per client IP before
+	// the key is looked up, so guessing keys is throttled too
+	"auth": {Limit: 300, Window: time.Minute},
+}
+
+// loadRateLimits reads RATE_LIMIT_<ROUTE> variables such as "100/1m". The
+// value "0" disables limiting for that route.
+func loadRateLimits() map[string]RateLimit {
+	limits := make(map[string]RateLimit, len(defaultRateLimits))
+
+	for name, def := range defaultRateLimits {
+		limits[name] = def
+
+		env := "RATE_LIMIT_" + strings.ToUpper(name)
+		value := os.Getenv(env)
+		if value == "" {
+			continue
+		}
+
+		limit, err := parseRateLimit(value)
+		if err != nil {
+			log.Printf("invalid %s=%q, using default: %v", env, value, err)
+			continue
+		}
+		limits[name] = limit
+	}
+
+	return limits
+}
+
+func parseRateLimit(value string) (RateLimit, error) {
+	if value == "0" {
+		return RateLimit{}, nil
+	}
+
+	count, window, found := strings.Cut(value, "/")
+	if !found {
+		return RateLimit{}, errors.New("expected <requests>/<duration>")
+	}
+
+	limit, err := strconv.ParseInt(count, 10, 64)
+	if err != nil || limit < 0 {
+		return RateLimit{}, errors.New("invalid request count")
+	}
+
+	d, err := time.ParseDuration(window)
+	if err != nil || d <= 0 {
+		return RateLimit{}, errors.New("invalid duration")
+	}
+
+	return RateLimit{Limit: limit, Window: d}, nil
+}
+
+// RateLimited limits requests to the named route using a token bucket kept in
+// Redis, so the limit holds across replicas. Clients are identified by the
+// owner of their api key when authenticated and by client IP otherwise.
+// Limiting fails open if Redis is unavailable.
+func (app *AppHandler) RateLimited(route string) func(http.Handler) http.Handler {
+	limit := app.RateLimits[route]
+
+	return func(next http.Handler) http.Handler {
+		if limit.Limit == 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := "ip:" + app.ClientIP.ClientIP(r)
+			if owner := ownerFromContext(r); owner != "" {
+				client = "owner:" + owner
+			}
+
+			res, err := app.Service.Redis.TakeToken("rl:"+route+":"+client, limit.Limit, limit.Window)
+			if err != nil {
+				log.Printf("rate limit check failed for %s: %v", route, err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.FormatInt(limit.Limit, 10))
+			w.Header().Set("RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+			w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", limit.Limit, ceilSeconds(limit.Window)))
+
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+				app.Response.ErrorJSON(w, errors.New("rate limit exceeded"), http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
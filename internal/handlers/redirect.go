@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hbrawnak/go-linko/internal/database"
+	"github.com/hbrawnak/go-linko/internal/targeting"
+	"github.com/hbrawnak/go-linko/internal/utils"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultRedirectCacheMaxAge = time.Hour
+
+const variantCookiePrefix = "linko_ab_"
+const variantCookieMaxAge = 30 * 24 * time.Hour
+
+// redirectStatuses are the status codes a link may redirect with.
+var redirectStatuses = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+}
+
+func isPermanentRedirect(status int) bool {
+	return status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect
+}
+
+func validateRedirectStatus(status int) error {
+	if status != 0 && !redirectStatuses[status] {
+		return errors.New("redirect_status must be one of 301, 302, 307 or 308")
+	}
+	return nil
+}
+
+// loadRedirectStatus reads the default status of links created without one.
+func loadRedirectStatus() int {
+	status := utils.GetEnvInt("REDIRECT_STATUS", http.StatusFound)
+	if !redirectStatuses[status] {
+		log.Printf("invalid REDIRECT_STATUS=%d, using %d", status, http.StatusFound)
+		return http.StatusFound
+	}
+	return status
+}
+
+// redirectWarning tells the creator of a permanently redirecting link that
+// repeat visits may bypass the service.
+func (app *AppHandler) redirectWarning(status int) string {
+	if status == 0 {
+		status = app.RedirectStatus
+	}
+	if !isPermanentRedirect(status) {
+		return ""
+	}
+	return fmt.Sprintf("browsers may cache permanent redirects for up to %s; repeat visits in that time are not counted", app.RedirectCacheMaxAge)
+}
+
+// redirect sends the visitor to the destination of link with the link's
+// status code. Temporary redirects are never cached, so every click is
+// counted. Permanent redirects may be cached for RedirectCacheMaxAge, unless
+// the link has restrictions that must be checked on every visit.
+func (app *AppHandler) redirect(w http.ResponseWriter, r *http.Request, link *database.CachedURL, target string) {
+	status, _ := strconv.Atoi(link.RedirectStatus)
+	if !redirectStatuses[status] {
+		status = app.RedirectStatus
+	}
+
+	if isPermanentRedirect(status) && link.Cacheable() && app.RedirectCacheMaxAge > 0 {
+		// Only browsers may keep it; shared caches would go on redirecting
+		// after the link is disabled or its destination blocklisted
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", ceilSeconds(app.RedirectCacheMaxAge)))
+	} else {
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+
+	http.Redirect(w, r, target, status)
+}
+
+// destination returns where a request for link should be redirected: the
+// URL of the first targeting rule matching the visitor, else the variant
+// for the visitor's preferred language, else the rotation variant assigned
+// to the visitor, else the link's URL. The link's query parameter templates
+// are added, and the query string and any path after the code are passed on
+// as the link allows. Extra path is rejected on links that do not forward
+// it.
+//
+// The returned variant names the language or rotation variant chosen for
+// click analytics; it is empty for links with a single destination.
+func (app *AppHandler) destination(w http.ResponseWriter, r *http.Request, code string, link *database.CachedURL) (string, string, error) {
+	rest, hasRest := strings.CutPrefix(r.URL.EscapedPath(), "/"+code+"/")
+	if hasRest && link.ForwardPath != "1" {
+		return "", "", utils.ErrUnsafePath
+	}
+
+	base, targeted := link.URL, false
+	if link.Targets != "" {
+		var rules []targeting.Rule
+		if err := database.DecodeJSONField(link.Targets, &rules); err != nil {
+			return "", "", err
+		}
+		if target, ok := targeting.Match(rules, app.visitor(r, rules)); ok {
+			base, targeted = target, true
+		}
+	}
+
+	var variant string
+	if link.Languages != "" && !targeted {
+		var languages map[string]string
+		if err := database.DecodeJSONField(link.Languages, &languages); err != nil {
+			return "", "", err
+		}
+		variant = languageVariant("default")
+		if tag, ok := targeting.NegotiateLanguage(languages, r.Header.Get("Accept-Language")); ok {
+			base, variant = languages[tag], languageVariant(tag)
+		}
+	}
+
+	if link.Variants != "" && !targeted {
+		var variants []targeting.Variant
+		if err := database.DecodeJSONField(link.Variants, &variants); err != nil {
+			return "", "", err
+		}
+		picked := app.rotate(w, r, code, link.Rotation, variants)
+		base, variant = picked.URL, rotationVariant(picked.Name)
+	}
+
+	if link.ForwardQuery == "" && link.QueryParams == "" && rest == "" {
+		return base, variant, nil
+	}
+
+	var params map[string]string
+	if err := database.DecodeJSONField(link.QueryParams, &params); err != nil {
+		return "", "", err
+	}
+
+	dest, err := url.Parse(base)
+	if err != nil {
+		return "", "", err
+	}
+
+	if rest != "" {
+		if dest, err = utils.ForwardPath(dest, rest); err != nil {
+			return "", "", err
+		}
+	}
+
+	incoming := r.URL.Query()
+	utils.ApplyQueryParams(dest, params, code, incoming, link.QueryOverride == "1")
+
+	if link.ForwardQuery != "" {
+		// Keys of the templates were settled above
+		for key := range params {
+			incoming.Del(key)
+		}
+		utils.ForwardQuery(dest, incoming, link.ForwardQuery)
+	}
+
+	return dest.String(), variant, nil
+}
+
+// rotate assigns the visitor of r one of the variants of link code. Cookie
+// rotation keeps the name of the first pick in a cookie scoped to the link;
+// a cookie naming a variant that no longer exists is replaced.
+func (app *AppHandler) rotate(w http.ResponseWriter, r *http.Request, code, rotation string, variants []targeting.Variant) targeting.Variant {
+	switch rotation {
+	case targeting.RotationIP:
+		return targeting.PickByKey(variants, code+"|"+app.ClientIP.ClientIP(r))
+	case targeting.RotationCookie:
+		if cookie, err := r.Cookie(variantCookiePrefix + code); err == nil {
+			if variant, ok := targeting.FindVariant(variants, cookie.Value); ok {
+				return variant
+			}
+		}
+
+		variant := targeting.PickRandom(variants)
+		http.SetCookie(w, &http.Cookie{
+			Name:     variantCookiePrefix + code,
+			Value:    variant.Name,
+			Path:     "/" + code,
+			MaxAge:   int(variantCookieMaxAge.Seconds()),
+			HttpOnly: true,
+			Secure:   strings.HasPrefix(os.Getenv("BASE_URL"), "https://"),
+			SameSite: http.SameSiteLaxMode,
+		})
+		return variant
+	default:
+		return targeting.PickRandom(variants)
+	}
+}
+
+// rotationVariant names a variant of a rotating link in click analytics.
+func rotationVariant(name string) string {
+	return "ab:" + name
+}
+
+// languageVariant names the variant of a language-targeted link in click
+// analytics.
+func languageVariant(tag string) string {
+	return "lang:" + tag
+}
+
+// visitor describes the visitor of r, looking up their location only when
+// a rule needs it.
+func (app *AppHandler) visitor(r *http.Request, rules []targeting.Rule) targeting.Visitor {
+	v := targeting.VisitorFromRequest(r)
+
+	if targeting.UsesLocation(rules) {
+		loc := app.Service.GeoIP.Lookup(app.ClientIP.ClientIP(r))
+		v.Country, v.Region = loc.Country, loc.Region
+	}
+
+	return v
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/hbrawnak/go-linko/internal/service"
+	"github.com/hbrawnak/go-linko/internal/utils"
+	"log"
+	"net/http"
+)
+
+const reportDetailsMaxLen = 1000
+
+type ReportRequest struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details,omitempty"`
+}
+
+// HandleReport lets anyone flag a link for review. Reporters are identified
+// by a hash of their IP and user agent, so repeat reports are ignored without
+// storing personal data.
+func (app *AppHandler) HandleReport(w http.ResponseWriter, r *http.Request) {
+	code := chi.URLParam(r, "code")
+
+	// Validating short code
+	if err := utils.ValidateShortCode(code); err != nil {
+		app.Response.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	var req ReportRequest
+	if err := app.Response.ReadJSON(w, r, &req); err != nil {
+		app.Response.ErrorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	if !service.ReportReasons[req.Reason] {
+		app.Response.ErrorJSON(w, errors.New("reason must be one of malware, phishing, spam, illegal or other"), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Details) > reportDetailsMaxLen {
+		app.Response.ErrorJSON(w, errors.New("details must be at most 1000 characters"), http.StatusBadRequest)
+		return
+	}
+
+	_, err := app.Service.ReportLink(code, req.Reason, req.Details, app.reporterFingerprint(r))
+	if err != nil {
+		if errors.Is(err, service.ErrNotFound) {
+			app.Response.ErrorJSON(w, errors.New("no result found"), http.StatusNotFound)
+			return
+		}
+		log.Printf("failed to store report for %s: %v", code, err)
+		app.Response.ErrorJSON(w, errors.New("failed to store report"), http.StatusInternalServerError)
+		return
+	}
+
+	payload := utils.JsonResponse{
+		Error:   false,
+		Message: "Report Received",
+	}
+
+	_ = app.Response.WriteJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *AppHandler) reporterFingerprint(r *http.Request) string {
+	sum := sha256.Sum256([]byte(app.ClientIP.ClientIP(r) + "|" + r.UserAgent()))
+	return hex.EncodeToString(sum[:])
+}
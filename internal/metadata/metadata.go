@@ -0,0 +1,190 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits on what is kept from a page, so a hostile page cannot bloat the
+// stored link.
+const (
+	titleMaxLen     = 300
+	valueMaxLen     = 1000
+	openGraphMaxLen = 30
+)
+
+// Metadata describes the page a link points to.
+type Metadata struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	// OpenGraph holds the og:* properties of the page keyed by property,
+	// such as "og:title" or "og:image".
+	OpenGraph  map[string]string `json:"open_graph,omitempty"`
+	FaviconURL string            `json:"favicon_url,omitempty"`
+	FetchedAt  time.Time         `json:"fetched_at"`
+}
+
+// Parse extracts the title, meta description, og:* properties and favicon
+// from the head of an HTML document. Relative URLs are resolved against
+// base. Parsing stops at the body, where none of them belong.
+func Parse(r io.Reader, base *url.URL) Metadata {
+	var md Metadata
+	var inTitle bool
+	var title strings.Builder
+
+	z := html.NewTokenizer(r)
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			md.Title = clean(title.String(), titleMaxLen)
+			return md
+
+		case html.TextToken:
+			if inTitle {
+				title.Write(z.Text())
+			}
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Title:
+				inTitle = false
+			case atom.Head:
+				md.Title = clean(title.String(), titleMaxLen)
+				return md
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			tag := atom.Lookup(name)
+			if tag == atom.Body {
+				md.Title = clean(title.String(), titleMaxLen)
+				return md
+			}
+			if tag == atom.Title && tt == html.StartTagToken {
+				inTitle = title.Len() == 0
+				continue
+			}
+			if !hasAttr {
+				continue
+			}
+
+			attrs := attributes(z)
+			switch tag {
+			case atom.Meta:
+				md.addMeta(attrs)
+			case atom.Link:
+				md.addIcon(attrs, base)
+			}
+		}
+	}
+}
+
+func (md *Metadata) addMeta(attrs map[string]string) {
+	content := clean(attrs["content"], valueMaxLen)
+	if content == "" {
+		return
+	}
+
+	if strings.EqualFold(attrs["name"], "description") && md.Description == "" {
+		md.Description = content
+		return
+	}
+
+	// og:* is meant to use the property attribute, but name is common too
+	property := strings.ToLower(attrs["property"])
+	if property == "" {
+		property = strings.ToLower(attrs["name"])
+	}
+	if !strings.HasPrefix(property, "og:") {
+		return
+	}
+
+	if md.OpenGraph == nil {
+		md.OpenGraph = make(map[string]string)
+	}
+	if _, ok := md.OpenGraph[property]; !ok && len(md.OpenGraph) < openGraphMaxLen {
+		md.OpenGraph[property] = content
+	}
+}
+
+// addIcon records the first icon link of the page. Only http and https
+// icons are kept, so the URL is safe to show.
+func (md *Metadata) addIcon(attrs map[string]string, base *url.URL) {
+	if md.FaviconURL != "" {
+		return
+	}
+
+	isIcon := false
+	for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+		if rel == "icon" || rel == "apple-touch-icon" {
+			isIcon = true
+		}
+	}
+	if !isIcon || attrs["href"] == "" {
+		return
+	}
+
+	icon, err := base.Parse(strings.TrimSpace(attrs["href"]))
+	if err != nil || (icon.Scheme != "http" && icon.Scheme != "https") || len(icon.String()) > valueMaxLen {
+		return
+	}
+	md.FaviconURL = icon.String()
+}
+
+func attributes(z *html.Tokenizer) map[string]string {
+	attrs := make(map[string]string)
+	for {
+		key, value, more := z.TagAttr()
+		attrs[string(key)] = string(value)
+		if !more {
+			return attrs
+		}
+	}
+}
+
+// clean collapses whitespace and cuts s to at most n bytes without
+// splitting a character.
+func clean(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) <= n {
+		return s
+	}
+
+	s = s[:n]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// OpenGraph holds the Open Graph tags a link owner chose for previews of the
+// short link, in place of those of the destination.
+type OpenGraph struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+}
+
+// Validate checks the lengths of the tags and that one is set.
+func (og *OpenGraph) Validate() error {
+	if og.Title == "" && og.Description == "" && og.Image == "" {
+		return errors.New("open_graph must set a title, description or image")
+	}
+	if utf8.RuneCountInString(og.Title) > titleMaxLen {
+		return fmt.Errorf("open_graph.title must be at most %d characters", titleMaxLen)
+	}
+	if utf8.RuneCountInString(og.Description) > valueMaxLen {
+		return fmt.Errorf("open_graph.description must be at most %d characters", valueMaxLen)
+	}
+	return nil
+}
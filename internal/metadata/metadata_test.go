@@ -0,0 +1,107 @@
+package metadata
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/post")
+
+	page := `<!DOCTYPE html>
+<html>
+<head>
+	<title>
+		A   post
+	</title>
+	<meta name="Description" content="What the post is about">
+	<meta property="og:title" content="OG title">
+	<meta name="og:image" content="https://cdn.example.com/cover.png">
+	<meta property="og:title" content="second og:title is ignored">
+	<meta property="twitter:card" content="summary">
+	<link rel="shortcut icon" href="../favicon.ico">
+	<link rel="apple-touch-icon" href="/touch.png">
+</head>
+<body>
+	<meta name="description" content="in the body">
+</body>
+</html>`
+
+	md := Parse(strings.NewReader(page), base)
+
+	if md.Title != "A post" {
+		t.Errorf("Title = %q, want %q", md.Title, "A post")
+	}
+	if md.Description != "What the post is about" {
+		t.Errorf("Description = %q, want %q", md.Description, "What the post is about")
+	}
+
+	wantOG := map[string]string{
+		"og:title": "OG title",
+		"og:image": "https://cdn.example.com/cover.png",
+	}
+	if len(md.OpenGraph) != len(wantOG) {
+		t.Errorf("OpenGraph = %v, want %v", md.OpenGraph, wantOG)
+	}
+	for property, want := range wantOG {
+		if got := md.OpenGraph[property]; got != want {
+			t.Errorf("OpenGraph[%q] = %q, want %q", property, got, want)
+		}
+	}
+
+	if want := "https://example.com/favicon.ico"; md.FaviconURL != want {
+		t.Errorf("FaviconURL = %q, want %q", md.FaviconURL, want)
+	}
+}
+
+func TestParseFavicon(t *testing.T) {
+	base, _ := url.Parse("https://example.com/a/b")
+
+	tests := []struct {
+		name string
+		head string
+		want string
+	}{
+		{"relative path", `<link rel="icon" href="icon.png">`, "https://example.com/a/icon.png"},
+		{"absolute path", `<link rel="icon" href="/icon.png">`, "https://example.com/icon.png"},
+		{"protocol relative", `<link rel="icon" href="//static.example.net/icon.png">`, "https://static.example.net/icon.png"},
+		{"absolute url", `<link rel="ICON" href="http://example.org/icon.png">`, "http://example.org/icon.png"},
+		{"not an icon", `<link rel="stylesheet" href="/style.css">`, ""},
+		{"unsafe scheme", `<link rel="icon" href="javascript:alert(1)">`, ""},
+		{"data url", `<link rel="icon" href="data:image/png;base64,AAAA">`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := Parse(strings.NewReader("<html><head>"+tt.head+"</head></html>"), base)
+			if md.FaviconURL != tt.want {
+				t.Errorf("FaviconURL = %q, want %q", md.FaviconURL, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	base, _ := url.Parse("https://example.com/")
+
+	long := strings.Repeat("é", titleMaxLen)
+	md := Parse(strings.NewReader("<title>"+long+"</title>"), base)
+
+	if len(md.Title) > titleMaxLen {
+		t.Errorf("len(Title) = %d, want at most %d", len(md.Title), titleMaxLen)
+	}
+	if !strings.HasPrefix(long, md.Title) {
+		t.Errorf("Title was not cut on a character boundary: %q", md.Title)
+	}
+
+	var page strings.Builder
+	for i := 0; i < openGraphMaxLen+10; i++ {
+		page.WriteString(`<meta property="og:p` + strings.Repeat("x", i) + `" content="v">`)
+	}
+	md = Parse(strings.NewReader(page.String()), base)
+
+	if len(md.OpenGraph) != openGraphMaxLen {
+		t.Errorf("len(OpenGraph) = %d, want %d", len(md.OpenGraph), openGraphMaxLen)
+	}
+}
@@ -0,0 +1,59 @@
+package pages
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+)
+
+//go:embed templates/*.html
+var files embed.FS
+
+var pages = parsePages()
+
+// parsePages parses every page together with the shared layout, so each
+// page only has to define its "title" and "content" blocks.
+func parsePages() map[string]*template.Template {
+	entries, err := files.ReadDir("templates")
+	if err != nil {
+		panic(err)
+	}
+
+	parsed := make(map[string]*template.Template)
+	for _, entry := range entries {
+		if entry.Name() == "layout.html" {
+			continue
+		}
+
+		name := entry.Name()[:len(entry.Name())-len(path.Ext(entry.Name()))]
+		parsed[name] = template.Must(template.ParseFS(files, "templates/layout.html", "templates/"+entry.Name()))
+	}
+
+	return parsed
+}
+
+// Render writes the named page with the given status. Pages are rendered to
+// a buffer first so a template error never produces a half-written page.
+func Render(w http.ResponseWriter, status int, name string, data any) error {
+	tmpl, ok := pages[name]
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "layout", data); err != nil {
+		log.Printf("failed to render page %s: %v", name, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return err
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
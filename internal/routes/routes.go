@@ -15,8 +15,8 @@ func SetupRoutes(handler *handlers.AppHandler) http.Handler {
 	mux.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "Idempotency-Key", "X-Admin-Actor"},
+		ExposedHeaders:   []string{"Link", "Idempotent-Replayed", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
@@ -24,9 +24,41 @@ func SetupRoutes(handler *handlers.AppHandler) http.Handler {
 	mux.Use(middleware.Heartbeat("/ping"))
 
 	mux.Get("/", handler.HandleMain)
-	mux.Post("/shorten", handler.HandleShorten)
-	mux.Get("/{code}", handler.HandleRedirect)
-	mux.Get("/stats/{code}", handler.HandleStats)
+	mux.Get("/.well-known/apple-app-site-association", handler.HandleAppleAppSiteAssociation)
+	mux.Get("/apple-app-site-association", handler.HandleAppleAppSiteAssociation)
+	mux.Get("/.well-known/assetlinks.json", handler.HandleAssetLinks)
+	mux.With(handler.RateLimited("redirect")).Get("/{code}", handler.HandleRedirect)
+	mux.With(handler.RateLimited("redirect")).Get("/{code}/*", handler.HandleRedirect)
+	mux.With(handler.RateLimited("redirect")).Get("/{code}+", handler.HandlePreview)
+	mux.With(handler.RateLimited("redirect")).Get("/preview/{code}", handler.HandlePreview)
+	mux.With(handler.RateLimited("unlock")).Post("/{code}", handler.HandleUnlock)
+	mux.With(handler.RateLimited("unlock")).Post("/{code}/*", handler.HandleUnlock)
+	mux.With(handler.RateLimited("report")).Post("/report/{code}", handler.HandleReport)
+
+	// Link management requires an api key and only exposes the key owner's links
+	mux.Group(func(r chi.Router) {
+		r.Use(handler.RateLimited("auth"))
+		r.Use(handler.RequireAPIKey)
+
+		r.With(handler.RateLimited("shorten"), handler.Idempotent).Post("/shorten", handler.HandleShorten)
+		r.With(handler.RateLimited("shorten"), handler.Idempotent).Post("/shorten/batch", handler.HandleShortenBatch)
+		r.With(handler.RateLimited("stats")).Get("/stats/{code}", handler.HandleStats)
+	})
+
+	mux.Route("/admin", func(r chi.Router) {
+		r.Use(handler.RequireAdmin)
+
+		r.Post("/api-keys", handler.HandleCreateAPIKey)
+		r.Get("/reports", handler.HandleListReports)
+		r.Get("/audit", handler.HandleListAudit)
+		r.Post("/links/{code}/disable", handler.HandleDisableLink)
+		r.Post("/links/{code}/restore", handler.HandleRestoreLink)
+		r.Delete("/links/{code}", handler.HandleDeleteLink)
+		r.Get("/geoip", handler.HandleGeoIPInfo)
+		r.Post("/geoip/reload", handler.HandleGeoIPReload)
+		r.Get("/owners/{owner}", handler.HandleGetOwnerSettings)
+		r.Put("/owners/{owner}", handler.HandleUpdateOwnerSettings)
+	})
 
 	return mux
 }
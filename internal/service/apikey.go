@@ -0,0 +1,75 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"github.com/hbrawnak/go-linko/internal/data"
+	"log"
+	"time"
+)
+
+const apiKeyPrefix = "lk_"
+
+// apiKeyCacheTTL bounds how long a revoked key keeps working on other replicas.
+const apiKeyCacheTTL = 5 * time.Minute
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// CreateAPIKey issues a new key for owner and returns its plaintext. Only
+// the hash is stored, so the plaintext cannot be recovered later.
+func (s *Service) CreateAPIKey(owner, name string) (string, *data.APIKey, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	plaintext := apiKeyPrefix + hex.EncodeToString(raw)
+
+	key := data.APIKey{
+		OwnerID: owner,
+		Name:    name,
+		KeyHash: hashAPIKey(plaintext),
+	}
+
+	id, err := s.Models.APIKey.Insert(key)
+	if err != nil {
+		return "", nil, err
+	}
+	key.ID = id
+	key.CreatedAt = time.Now()
+
+	return plaintext, &key, nil
+}
+
+// ResolveAPIKey returns the owner of a plaintext key. Lookups are cached in
+// Redis by key hash to keep Postgres off the request path.
+func (s *Service) ResolveAPIKey(plaintext string) (string, error) {
+	hash := hashAPIKey(plaintext)
+	cacheKey := "apikey:" + hash
+
+	if owner, err := s.Redis.Get(cacheKey); err == nil && owner != "" {
+		return owner, nil
+	}
+
+	key, err := s.Models.APIKey.GetActiveByHash(hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrInvalidAPIKey
+		}
+		log.Printf("failed to look up api key: %v", err)
+		return "", err
+	}
+
+	if err := s.Redis.Set(cacheKey, key.OwnerID, apiKeyCacheTTL); err != nil {
+		log.Printf("failed to cache api key: %v", err)
+	}
+
+	return key.OwnerID, nil
+}
+
+func hashAPIKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
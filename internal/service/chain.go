@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/hbrawnak/go-linko/internal/utils"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Error codes reported when a destination points at a short link.
+const (
+	CodeSelfLink            = "self_link"
+	CodeSelfLinkNotFound    = "self_link_not_found"
+	CodeShortenerNotAllowed = "shortener_not_allowed"
+	CodeShortenerUnresolved = "shortener_unresolved"
+	CodeRedirectLoop        = "redirect_loop"
+)
+
+// Modes for handling destinations that are themselves short links.
+const (
+	ChainAllow   = "allow"
+	ChainReject  = "reject"
+	ChainResolve = "resolve"
+)
+
+const defaultChainMaxHops = 5
+const resolveTimeout = 5 * time.Second
+
+// defaultShorteners are well-known third-party shortener domains.
+var defaultShorteners = []string{
+	"bit.ly", "bitly.com", "t.co", "tinyurl.com", "goo.gl", "ow.ly", "is.gd",
+	"buff.ly", "rebrand.ly", "cutt.ly", "shorturl.at", "tiny.cc", "rb.gy",
+	"t.ly", "bl.ink", "s.id", "v.gd", "lnkd.in",
+}
+
+// ChainPolicy decides what happens to destinations that are short links,
+// either our own (which could create loops) or another shortener's (which
+// hide the real destination).
+type ChainPolicy struct {
+	OwnDomains    *utils.HostList
+	OwnMode       string // reject or resolve
+	Shorteners    *utils.HostList
+	ShortenerMode string // allow, reject or resolve
+	Resolver      LinkResolver
+	MaxHops       int
+}
+
+// LoadChainPolicyFromEnv builds the policy from BASE_URL, SHORT_DOMAINS,
+// SELF_LINK_MODE, KNOWN_SHORTENERS and SHORTENER_MODE. Third-party links are
+// resolved through guard when it is set.
+func LoadChainPolicyFromEnv(guard *NetworkGuard) *ChainPolicy {
+	own := utils.NewHostList(utils.SplitList(utils.GetEnv("SHORT_DOMAINS", "")))
+	if base, err := url.Parse(utils.GetEnv("BASE_URL", "")); err == nil && base.Hostname() != "" {
+		own.Add(base.Hostname())
+	}
+
+	shorteners := defaultShorteners
+	if list := utils.GetEnv("KNOWN_SHORTENERS", ""); list != "" {
+		shorteners = utils.SplitList(list)
+	}
+
+	return &ChainPolicy{
+		OwnDomains:    own,
+		OwnMode:       utils.GetEnv("SELF_LINK_MODE", ChainReject),
+		Shorteners:    utils.NewHostList(shorteners),
+		ShortenerMode: utils.GetEnv("SHORTENER_MODE", ChainAllow),
+		Resolver:      NewHTTPLinkResolver(resolveTimeout, defaultChainMaxHops, guard),
+		MaxHops:       defaultChainMaxHops,
+	}
+}
+
+// ResolveChain checks a validated destination against the ChainPolicy and
+// returns the destination to store, which differs from u when a short link
+// was resolved to its target.
+func (s *Service) ResolveChain(u string) (string, error) {
+	p := s.Chain
+	if p == nil {
+		return u, nil
+	}
+
+	for hop := 0; hop < p.MaxHops; hop++ {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return "", err
+		}
+		host := parsed.Hostname()
+
+		switch {
+		case p.OwnDomains.Contains(host):
+			if p.OwnMode != ChainResolve {
+				return "", utils.NewPolicyError(CodeSelfLink, "url points to a link on this shortener")
+			}
+
+			target, err := s.lookupDestination(pathCode(parsed))
+			if err != nil {
+				return "", err
+			}
+			u = target
+
+		case p.Shorteners.Contains(host):
+			switch p.ShortenerMode {
+			case ChainReject:
+				return "", utils.NewPolicyError(CodeShortenerNotAllowed, "links from %s are not allowed", host)
+			case ChainResolve:
+				target, err := s.resolveExternal(u)
+				if err != nil {
+					return "", err
+				}
+				u = target
+			default:
+				return u, nil
+			}
+
+		default:
+			return u, nil
+		}
+
+		// The target was not checked by the handler, so it must pass the policy too
+		if err := utils.ValidateOriginalURL(u); err != nil {
+			return "", err
+		}
+	}
+
+	return "", utils.NewPolicyError(CodeRedirectLoop, "url redirects through too many short links")
+}
+
+func (s *Service) resolveExternal(u string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	target, err := s.Chain.Resolver.Resolve(ctx, u)
+	if err != nil {
+		log.Printf("failed to resolve %s: %v", u, err)
+		var policyErr *utils.PolicyError
+		if errors.As(err, &policyErr) {
+			return "", policyErr
+		}
+		if errors.Is(err, ErrTooManyRedirects) {
+			return "", utils.NewPolicyError(CodeRedirectLoop, "url redirects too many times")
+		}
+		return "", utils.NewPolicyError(CodeShortenerUnresolved, "unable to resolve the shortened url")
+	}
+
+	return target, nil
+}
+
+// lookupDestination returns the destination of one of our own codes. Only
+// links that redirect anyone right now are resolved; the destination of a
+// protected, click-limited, disabled, expired or pending link must not be
+// handed out through a new link.
+func (s *Service) lookupDestination(code string) (string, error) {
+	if utils.ValidateShortCode(code) != nil {
+		return "", utils.NewPolicyError(CodeSelfLinkNotFound, "url points to an unknown link on this shortener")
+	}
+
+	link, err := s.ResolveLink(code)
+	if err != nil {
+		return "", utils.NewPolicyError(CodeSelfLinkNotFound, "url points to an unknown link on this shortener")
+	}
+
+	if link.IsDisabled() || link.IsExpired() || link.IsPending() || link.IsProtected() || link.IsClickLimited() {
+		return "", utils.NewPolicyError(CodeSelfLink, "url points to a restricted link on this shortener")
+	}
+
+	return link.URL, nil
+}
+
+// pathCode extracts the short code from the path of a link on our own domain.
+func pathCode(u *url.URL) string {
+	code, _, _ := strings.Cut(strings.TrimLeft(u.Path, "/"), "/")
+	return code
+}
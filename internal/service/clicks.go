@@ -0,0 +1,141 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/hbrawnak/go-linko/internal/database"
+	"log"
+	"strconv"
+	"time"
+)
+
+var ErrClicksExhausted = errors.New("link has reached its click limit")
+
+// InitClicks starts the click counter of a new click-limited link.
+func (s *Service) InitClicks(code string, maxClicks int64, ttl time.Duration) {
+	if err := s.Redis.SeedClicks(database.ClicksKey(code), maxClicks, ttl); err != nil {
+		log.Printf("failed to start click counter for %s: %v", code, err)
+	}
+}
+
+// ConsumeClick takes one click from a click-limited link and returns the
+// clicks left, or ErrClicksExhausted once none are left. The counter lives
+// in Redis so the decrement is atomic across replicas; every click is
+// mirrored to the database, which takes over when Redis is unavailable and
+// reseeds the counter after it is evicted.
+func (s *Service) ConsumeClick(code string, link *database.CachedURL) (int64, error) {
+	key := database.ClicksKey(code)
+
+	remaining, err := s.Redis.ConsumeClick(key)
+	if err == nil && remaining == database.ClicksNotSeeded {
+		if err = s.seedClicks(code, link); err == nil {
+			remaining, err = s.Redis.ConsumeClick(key)
+		}
+	}
+
+	if err != nil {
+		log.Printf("click counter unavailable for %s, using database: %v", code, err)
+		remaining, err = s.Models.URL.ConsumeClick(code)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrClicksExhausted
+		}
+		return remaining, err
+	}
+
+	if remaining == database.ClicksExhausted {
+		return 0, ErrClicksExhausted
+	}
+
+	s.recordClickBG(code)
+	return remaining, nil
+}
+
+// ClicksRemaining returns the live click count of a link, or fallback when
+// the counter is not in Redis.
+func (s *Service) ClicksRemaining(code string, fallback int64) int64 {
+	value, err := s.Redis.Get(database.ClicksKey(code))
+	if err != nil {
+		return fallback
+	}
+
+	remaining, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return fallback
+	}
+
+	return max(remaining, 0)
+}
+
+// HasClicksLeft reports whether a click-limited link has clicks left
+// without taking one. It answers true when the counter cannot be read, so
+// callers must not reveal the destination based on it alone.
+func (s *Service) HasClicksLeft(code string, link *database.CachedURL) bool {
+	key := database.ClicksKey(code)
+
+	value, err := s.Redis.Get(key)
+	if err != nil {
+		if err := s.seedClicks(code, link); err != nil {
+			return true
+		}
+		if value, err = s.Redis.Get(key); err != nil {
+			return true
+		}
+	}
+
+	remaining, err := strconv.ParseInt(value, 10, 64)
+	return err != nil || remaining > 0
+}
+
+// seedClicks recreates a missing counter from the database, or from the
+// cached limit when the link has not been persisted yet.
+func (s *Service) seedClicks(code string, link *database.CachedURL) error {
+	remaining, err := strconv.ParseInt(link.MaxClicks, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if link.Persisted == "1" {
+		u, err := s.Models.URL.GetOne(code)
+		if err != nil {
+			return err
+		}
+		remaining = u.ClicksRemaining()
+	}
+
+	return s.Redis.SeedClicks(database.ClicksKey(code), remaining, database.TTLUntil(nil))
+}
+
+// SyncClicks copies the clicks used so far from the counter of a freshly
+// persisted link into its row. Until then clicks could only be counted in
+// Redis, and a counter rebuilt from a row that never saw them would allow
+// them again. A click still being recorded may be counted twice, which errs
+// on the side of the limit.
+func (s *Service) SyncClicks(code string, maxClicks int64) {
+	if maxClicks <= 0 {
+		return
+	}
+
+	value, err := s.Redis.Get(database.ClicksKey(code))
+	if err != nil {
+		return
+	}
+
+	remaining, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || remaining >= maxClicks {
+		return
+	}
+
+	if err := s.Models.URL.SyncClicksUsed(code, maxClicks-max(remaining, 0)); err != nil {
+		log.Printf("failed to sync clicks of %s: %v", code, err)
+	}
+}
+
+// recordClickBG mirrors a click to the database. Links the worker has not
+// persisted yet have no row; their clicks are copied over by SyncClicks.
+func (s *Service) recordClickBG(code string) {
+	go func() {
+		if _, err := s.Models.URL.ConsumeClick(code); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("failed to record click of %s: %v", code, err)
+		}
+	}()
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hbrawnak/go-linko/internal/metadata"
+	"github.com/hbrawnak/go-linko/internal/utils"
+	"golang.org/x/net/html/charset"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	metadataTimeout      = 5 * time.Second
+	metadataMaxBytes     = 512 << 10
+	metadataMaxRedirects = 3
+	metadataConcurrency  = 4
+	metadataQueueSize    = 1000
+)
+
+// MetadataFetcher retrieves the pages links point to and extracts their
+// metadata. Connections always go through a NetworkGuard dialer, since
+// fetches happen for every link without any user being involved.
+type MetadataFetcher struct {
+	Client   *http.Client
+	MaxBytes int64
+
+	// queue holds links waiting for one of the metadataConcurrency workers,
+	// so a large batch neither opens hundreds of connections nor starts a
+	// goroutine per link.
+	queue chan metadataJob
+}
+
+type metadataJob struct {
+	code, owner, url string
+}
+
+// NewMetadataFetcherFromEnv returns a fetcher unless METADATA_FETCH is set
+// to false. guard may be nil, in which case a guard without owner
+// exceptions is used.
+func NewMetadataFetcherFromEnv(guard *NetworkGuard) *MetadataFetcher {
+	if enabled := utils.GetEnv("METADATA_FETCH", "true"); enabled == "false" || enabled == "0" {
+		return nil
+	}
+
+	if guard == nil {
+		guard = &NetworkGuard{Resolver: net.DefaultResolver, LookupTimeout: networkLookupTimeout}
+	}
+
+	return NewMetadataFetcher(metadataTimeout, metadataMaxBytes, guard)
+}
+
+func NewMetadataFetcher(timeout time.Duration, maxBytes int64, guard *NetworkGuard) *MetadataFetcher {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = guard.Dialer().DialContext
+	transport.ResponseHeaderTimeout = timeout
+
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > metadataMaxRedirects {
+				return ErrTooManyRedirects
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("refusing to follow redirect to %s", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+
+	return &MetadataFetcher{
+		Client:   client,
+		MaxBytes: maxBytes,
+		queue:    make(chan metadataJob, metadataQueueSize),
+	}
+}
+
+var errNotHTML = errors.New("destination is not an html page")
+
+// Fetch retrieves u and returns the metadata of the page. At most MaxBytes
+// of the body are read.
+func (f *MetadataFetcher) Fetch(ctx context.Context, u string) (*metadata.Metadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	req.Header.Set("User-Agent", "go-linko metadata fetcher")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("destination answered %s", resp.Status)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, errNotHTML
+	}
+
+	body, err := charset.NewReader(io.LimitReader(resp.Body, f.MaxBytes), contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	md := metadata.Parse(body, resp.Request.URL)
+	md.FetchedAt = time.Now()
+	return &md, nil
+}
+
+// StartMetadataWorkers starts the workers fetching the metadata queued by
+// FetchMetadataBG.
+func (s *Service) StartMetadataWorkers() {
+	f := s.Metadata
+	if f == nil {
+		return
+	}
+
+	for i := 0; i < metadataConcurrency; i++ {
+		go func() {
+			for job := range f.queue {
+				s.fetchMetadata(job)
+			}
+		}()
+	}
+}
+
+// FetchMetadataBG queues the metadata of a persisted link to be fetched in
+// the background and stored on the link. Links are dropped when the queue
+// is full; the link works the same without metadata.
+func (s *Service) FetchMetadataBG(code, owner, u string) {
+	f := s.Metadata
+	if f == nil {
+		return
+	}
+
+	if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return
+	}
+
+	select {
+	case f.queue <- metadataJob{code: code, owner: owner, url: u}:
+	default:
+		log.Printf("metadata queue is full, not fetching metadata of %s", code)
+	}
+}
+
+// fetchMetadata fetches and stores the metadata of one link. Failures are
+// only logged.
+func (s *Service) fetchMetadata(job metadataJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), metadataTimeout)
+	defer cancel()
+
+	md, err := s.Metadata.Fetch(ctx, job.url)
+	if err != nil {
+		log.Printf("failed to fetch metadata of %s: %v", job.code, err)
+		return
+	}
+
+	if err := s.Models.URL.SetMetadata(job.code, md); err != nil {
+		log.Printf("failed to store metadata of %s: %v", job.code, err)
+		return
+	}
+
+	// Stats include the metadata
+	if err := s.Redis.Del("stats:" + job.owner + ":" + job.code); err != nil {
+		log.Printf("failed to invalidate stats of %s: %v", job.code, err)
+	}
+}
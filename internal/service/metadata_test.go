@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/hbrawnak/go-linko/internal/utils"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestFetcher returns a fetcher guarded like in production, except that
+// it may connect to the test servers in allowed, which listen on loopback.
+func newTestFetcher(timeout time.Duration, maxBytes int64, allowed ...*httptest.Server) *MetadataFetcher {
+	f := NewMetadataFetcher(timeout, maxBytes, &NetworkGuard{Resolver: net.DefaultResolver, LookupTimeout: time.Second})
+
+	transport := f.Client.Transport.(*http.Transport)
+	guarded := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		for _, srv := range allowed {
+			if address == srv.Listener.Addr().String() {
+				var d net.Dialer
+				return d.DialContext(ctx, network, address)
+			}
+		}
+		return guarded(ctx, network, address)
+	}
+
+	return f
+}
+
+func TestMetadataFetcherFetch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+		// "Café" in Latin-1
+		_, _ = w.Write([]byte("<html><head><title>Caf\xe9</title><link rel=icon href=/favicon.ico></head></html>"))
+	}))
+	defer srv.Close()
+
+	f := newTestFetcher(time.Second, metadataMaxBytes, srv)
+	md, err := f.Fetch(context.Background(), srv.URL+"/page")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	if md.Title != "Café" {
+		t.Errorf("Title = %q, want %q", md.Title, "Café")
+	}
+	if want := srv.URL + "/favicon.ico"; md.FaviconURL != want {
+		t.Errorf("FaviconURL = %q, want %q", md.FaviconURL, want)
+	}
+	if md.FetchedAt.IsZero() {
+		t.Error("FetchedAt is not set")
+	}
+}
+
+func TestMetadataFetcherSizeCap(t *testing.T) {
+	const maxBytes = 1024
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte(`<html><head><meta name="description" content="early">`))
+		_, _ = w.Write([]byte(strings.Repeat(" ", 4*maxBytes)))
+		_, _ = w.Write([]byte(`<title>past the cap</title></head></html>`))
+	}))
+	defer srv.Close()
+
+	f := newTestFetcher(time.Second, maxBytes, srv)
+	md, err := f.Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	if md.Description != "early" {
+		t.Errorf("Description = %q, want %q", md.Description, "early")
+	}
+	if md.Title != "" {
+		t.Errorf("Title = %q, want it cut off by the size cap", md.Title)
+	}
+}
+
+func TestMetadataFetcherTimeout(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	f := newTestFetcher(100*time.Millisecond, metadataMaxBytes, srv)
+
+	start := time.Now()
+	if _, err := f.Fetch(context.Background(), srv.URL); err == nil {
+		t.Fatal("Fetch of a hanging server succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Fetch took %s, want it to time out", elapsed)
+	}
+}
+
+func TestMetadataFetcherRejectsNonHTML(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"title": "not a page"}`))
+	}))
+	defer srv.Close()
+
+	f := newTestFetcher(time.Second, metadataMaxBytes, srv)
+	if _, err := f.Fetch(context.Background(), srv.URL); !errors.Is(err, errNotHTML) {
+		t.Errorf("Fetch error = %v, want %v", err, errNotHTML)
+	}
+}
+
+func TestMetadataFetcherRefusesPrivateRedirect(t *testing.T) {
+	var internalHits atomic.Int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internalHits.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		_, _ = w.Write([]byte("<title>internal</title>"))
+	}))
+	defer internal.Close()
+
+	public := httptest.NewServer(http.RedirectHandler(internal.URL+"/admin", http.StatusFound))
+	defer public.Close()
+
+	// Only the first hop is let through; the redirect target is on
+	// loopback and must be refused by the guard's dialer
+	f := newTestFetcher(time.Second, metadataMaxBytes, public)
+	_, err := f.Fetch(context.Background(), public.URL)
+
+	var policyErr *utils.PolicyError
+	if !errors.As(err, &policyErr) || policyErr.Code != CodeDestinationNotPublic {
+		t.Errorf("Fetch error = %v, want %s", err, CodeDestinationNotPublic)
+	}
+	if n := internalHits.Load(); n != 0 {
+		t.Errorf("internal server was reached %d times", n)
+	}
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/hbrawnak/go-linko/internal/data"
+	"github.com/hbrawnak/go-linko/internal/database"
+	"log"
+)
+
+// Admin actions recorded in the audit log.
+const (
+	AuditLinkDisabled = "link.disable"
+	AuditLinkRestored = "link.restore"
+	AuditLinkDeleted  = "link.delete"
+	AuditAPIKeyCreate = "api_key.create"
+	AuditGeoIPReload  = "geoip.reload"
+	AuditOwnerUpdate  = "owner.update"
+)
+
+// ReportReasons are the accepted reasons for reporting a link.
+var ReportReasons = map[string]bool{
+	"malware":  true,
+	"phishing": true,
+	"spam":     true,
+	"illegal":  true,
+	"other":    true,
+}
+
+// ReportLink records a public report. It returns false when the reporter
+// already reported the link.
+func (s *Service) ReportLink(code, reason, details, fingerprint string) (bool, error) {
+	if _, err := s.ResolveLink(code); err != nil {
+		return false, err
+	}
+
+	return s.Models.Report.Insert(data.Report{
+		ShortCode:           code,
+		Reason:              reason,
+		Details:             details,
+		ReporterFingerprint: fingerprint,
+	})
+}
+
+// SetLinkDisabled disables or restores a link, resolves its open reports and
+// records the action for actor in the audit log.
+func (s *Service) SetLinkDisabled(code string, disabled bool, reason string, actor data.AuditEntry) error {
+	if err := s.Models.URL.SetDisabled(code, disabled, reason); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if u, err := s.Models.URL.GetOne(code); err == nil {
+		s.invalidateLink(u)
+	}
+
+	if err := s.Models.Report.ResolveForCode(code); err != nil {
+		log.Printf("failed to resolve reports for %s: %v", code, err)
+	}
+
+	actor.Action = AuditLinkRestored
+	if disabled {
+		actor.Action = AuditLinkDisabled
+	}
+	actor.ShortCode = code
+	actor.Details = reason
+	s.Audit(actor)
+
+	return nil
+}
+
+// DeleteLink permanently removes a link and records the action.
+func (s *Service) DeleteLink(code, reason string, actor data.AuditEntry) error {
+	u, err := s.Models.URL.GetOne(code)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	if err := s.Models.URL.Delete(code); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	s.invalidateLink(u)
+
+	if err := s.Models.Report.ResolveForCode(code); err != nil {
+		log.Printf("failed to resolve reports for %s: %v", code, err)
+	}
+
+	actor.Action = AuditLinkDeleted
+	actor.ShortCode = code
+	actor.Details = reason
+	if actor.Details == "" {
+		actor.Details = "destination: " + u.OriginalURL
+	}
+	s.Audit(actor)
+
+	return nil
+}
+
+// Audit stores an audit log entry. Failures are logged rather than returned
+// because the action itself has already happened.
+func (s *Service) Audit(entry data.AuditEntry) {
+	if err := s.Models.AuditEntry.Insert(entry); err != nil {
+		log.Printf("failed to write audit entry %s for %s: %v", entry.Action, entry.ShortCode, err)
+	}
+}
+
+// invalidateLink drops every cached view of a link so the next request sees
+// the database state.
+func (s *Service) invalidateLink(u *data.URL) {
+	keys := []string{u.ShortCode, "stats:" + u.OwnerID + ":" + u.ShortCode, database.ClicksKey(u.ShortCode)}
+	if u.NormalizedHash != "" {
+		keys = append(keys, normalizedKey(u.OwnerID, u.NormalizedHash))
+	}
+
+	for _, key := range keys {
+		if err := s.Redis.Del(key); err != nil {
+			log.Printf("failed to invalidate cache key %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/hbrawnak/go-linko/internal/utils"
+	"log"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Error codes reported by the network guard.
+const (
+	CodeDestinationNotPublic    = "destination_not_public"
+	CodeDestinationUnresolvable = "destination_unresolvable"
+)
+
+const networkLookupTimeout = 2 * time.Second
+const networkDialTimeout = 5 * time.Second
+
+// HostResolver looks up the addresses of a host. *net.Resolver satisfies it;
+// tests can substitute a fake.
+type HostResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// NetworkGuard rejects destinations that resolve to loopback, link-local,
+// private or otherwise reserved addresses, which would let a link reach
+// internal services from any component that fetches destinations.
+type NetworkGuard struct {
+	Resolver      HostResolver
+	AllowedOwners map[string]bool
+	LookupTimeout time.Duration
+}
+
+var blockedNetworks = mustParseCIDRs(
+	// IPv4
+	"0.0.0.0/8",          // "this" network
+	"10.0.0.0/8",         // private
+	"100.64.0.0/10",      // carrier-grade NAT
+	"127.0.0.0/8",        // loopback
+	"169.254.0.0/16",     // link-local, including cloud metadata endpoints
+	"172.16.0.0/12",      // private
+	"192.0.0.0/24",       // IETF protocol assignments
+	"192.0.2.0/24",       // documentation
+	"192.88.99.0/24",     // 6to4 relay anycast
+	"192.168.0.0/16",     // private
+	"198.18.0.0/15",      // benchmarking
+	"198.51.100.0/24",    // documentation
+	"203.0.113.0/24",     // documentation
+	"224.0.0.0/4",        // multicast
+	"240.0.0.0/4",        // reserved
+	"255.255.255.255/32", // broadcast
+	// IPv6
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"64:ff9b::/96",   // NAT64
+	"64:ff9b:1::/48", // local-use NAT64
+	"100::/64",       // discard-only
+	"2001::/23",      // IETF protocol assignments
+	"2001:db8::/32",  // documentation
+	"2002::/16",      // 6to4
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link-local
+	"fec0::/10",      // site-local
+	"ff00::/8",       // multicast
+)
+
+// NewNetworkGuardFromEnv returns a guard when SSRF_PROTECTION is enabled,
+// or nil when the check is turned off.
+func NewNetworkGuardFromEnv() *NetworkGuard {
+	if !utils.GetEnvBool("SSRF_PROTECTION") {
+		return nil
+	}
+
+	allowed := make(map[string]bool)
+	for _, owner := range utils.SplitList(utils.GetEnv("SSRF_ALLOWED_OWNERS", "")) {
+		allowed[owner] = true
+	}
+
+	return &NetworkGuard{
+		Resolver:      net.DefaultResolver,
+		AllowedOwners: allowed,
+		LookupTimeout: networkLookupTimeout,
+	}
+}
+
+// IsPublicIP reports whether ip is a globally routable unicast address.
+func IsPublicIP(ip net.IP) bool {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// CheckURL resolves the host of u and fails if any of its addresses is not
+// public. Owners on the allowlist may link to internal hosts.
+func (g *NetworkGuard) CheckURL(u, owner string) error {
+	if g == nil || g.AllowedOwners[owner] {
+		return nil
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+
+	return g.CheckHost(parsed.Hostname())
+}
+
+func (g *NetworkGuard) CheckHost(host string) error {
+	if ip := net.ParseIP(host); ip != nil {
+		return checkIP(ip)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.LookupTimeout)
+	defer cancel()
+
+	addrs, err := g.Resolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		log.Printf("failed to resolve destination host %s: %v", host, err)
+		return utils.NewPolicyError(CodeDestinationUnresolvable, "url host %q could not be resolved", host)
+	}
+
+	for _, addr := range addrs {
+		if err := checkIP(addr.IP); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Dialer returns a dialer that refuses to connect to non-public addresses.
+// The check runs on the address actually dialed, so it also protects against
+// DNS answers changing between validation and fetch.
+func (g *NetworkGuard) Dialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout: networkDialTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("refusing to dial unresolved address %s", address)
+			}
+
+			return checkIP(ip)
+		},
+	}
+}
+
+func checkIP(ip net.IP) error {
+	if !IsPublicIP(ip) {
+		return utils.NewPolicyError(CodeDestinationNotPublic, "url resolves to a non-public address")
+	}
+	return nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
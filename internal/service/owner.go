@@ -0,0 +1,64 @@
+package service
+
+import (
+	"fmt"
+	"github.com/hbrawnak/go-linko/internal/data"
+	"log"
+	"time"
+)
+
+// ownerCacheTTL bounds how long other replicas keep using old settings.
+const ownerCacheTTL = 5 * time.Minute
+
+// OwnerInterstitial reports whether every link of owner shows an
+// interstitial. Errors are logged and treated as false, so a database outage
+// does not block redirects.
+func (s *Service) OwnerInterstitial(owner string) bool {
+	if owner == "" {
+		return false
+	}
+
+	cacheKey := ownerInterstitialKey(owner)
+	if cached, err := s.Redis.Get(cacheKey); err == nil && cached != "" {
+		return cached == "1"
+	}
+
+	settings, err := s.Models.OwnerSettings.Get(owner)
+	if err != nil {
+		log.Printf("failed to load settings of owner %s: %v", owner, err)
+		return false
+	}
+
+	value := "0"
+	if settings.Interstitial {
+		value = "1"
+	}
+	if err := s.Redis.Set(cacheKey, value, ownerCacheTTL); err != nil {
+		log.Printf("failed to cache settings of owner %s: %v", owner, err)
+	}
+
+	return settings.Interstitial
+}
+
+// SetOwnerInterstitial changes whether the links of owner show an
+// interstitial and records the change in the audit log. It applies to
+// existing links as well, within ownerCacheTTL on other replicas.
+func (s *Service) SetOwnerInterstitial(owner string, enabled bool, actor data.AuditEntry) error {
+	if err := s.Models.OwnerSettings.Upsert(data.OwnerSettings{OwnerID: owner, Interstitial: enabled}); err != nil {
+		return err
+	}
+
+	if err := s.Redis.Del(ownerInterstitialKey(owner)); err != nil {
+		log.Printf("failed to invalidate settings of owner %s: %v", owner, err)
+	}
+
+	actor.Action = AuditOwnerUpdate
+	actor.Details = fmt.Sprintf("owner: %s, interstitial: %t", owner, enabled)
+	s.Audit(actor)
+
+	return nil
+}
+
+func ownerInterstitialKey(owner string) string {
+	return "owner:" + owner + ":interstitial"
+}
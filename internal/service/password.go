@@ -0,0 +1,26 @@
+package service
+
+import (
+	"github.com/hbrawnak/go-linko/internal/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HashLinkPassword returns the bcrypt hash stored for a protected link, or
+// an empty string when password is empty.
+func (s *Service) HashLinkPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// CheckLinkPassword reports whether password matches the link's hash.
+func (s *Service) CheckLinkPassword(link *database.CachedURL, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) == nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// LinkResolver follows a link through its redirects and returns the final
+// destination. It is an interface so tests can stub network access.
+type LinkResolver interface {
+	Resolve(ctx context.Context, u string) (string, error)
+}
+
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// HTTPLinkResolver resolves links by issuing HEAD requests and following
+// Location headers itself, one hop at a time.
+type HTTPLinkResolver struct {
+	Client  *http.Client
+	MaxHops int
+}
+
+// NewHTTPLinkResolver creates a resolver. When guard is set, every hop is
+// checked against it at connect time.
+func NewHTTPLinkResolver(timeout time.Duration, maxHops int, guard *NetworkGuard) *HTTPLinkResolver {
+	client := &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	if guard != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		transport.DialContext = guard.Dialer().DialContext
+		client.Transport = transport
+	}
+
+	return &HTTPLinkResolver{
+		Client:  client,
+		MaxHops: maxHops,
+	}
+}
+
+func (h *HTTPLinkResolver) Resolve(ctx context.Context, u string) (string, error) {
+	current := u
+
+	for hop := 0; hop < h.MaxHops; hop++ {
+		next, err := h.next(ctx, current)
+		if err != nil {
+			return "", err
+		}
+		if next == "" {
+			return current, nil
+		}
+		current = next
+	}
+
+	return "", ErrTooManyRedirects
+}
+
+// next returns the Location a URL redirects to, or an empty string when it
+// does not redirect.
+func (h *HTTPLinkResolver) next(ctx context.Context, u string) (string, error) {
+	resp, err := h.do(ctx, http.MethodHead, u)
+	if err == nil && resp.StatusCode == http.StatusMethodNotAllowed {
+		resp, err = h.do(ctx, http.MethodGet, u)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return "", nil
+	}
+
+	location, err := resp.Location()
+	if err != nil {
+		if errors.Is(err, http.ErrNoLocation) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return location.String(), nil
+}
+
+func (h *HTTPLinkResolver) do(ctx context.Context, method, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	return resp, nil
+}
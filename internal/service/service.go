@@ -1,19 +1,46 @@
 package service
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/hbrawnak/go-linko/internal/blocklist"
 	"github.com/hbrawnak/go-linko/internal/data"
 	"github.com/hbrawnak/go-linko/internal/database"
+	"github.com/hbrawnak/go-linko/internal/geoip"
+	"github.com/hbrawnak/go-linko/internal/metadata"
 	"github.com/hbrawnak/go-linko/internal/utils"
 	"log"
+	"strconv"
 	"time"
 )
 
+var ErrNotFound = errors.New("short code not found")
+
 type Service struct {
 	Models data.Models
 	Redis  database.RedisClient
+
+	// StripTrackingParams drops utm_* and click id parameters before
+	// hashing destinations for reuse_existing lookups.
+	StripTrackingParams bool
+
+	// Chain controls destinations that are themselves short links.
+	Chain *ChainPolicy
+
+	// Network rejects destinations on internal networks; nil disables it.
+	Network *NetworkGuard
+
+	// Blocklist holds known-bad destinations; nil disables it.
+	Blocklist *blocklist.Blocklist
+
+	// GeoIP locates visitors for geo targeting; nil disables it.
+	GeoIP *geoip.DB
+
+	// Metadata fetches destination pages after links are stored; nil
+	// disables it.
+	Metadata *MetadataFetcher
 }
 
 type StatsData struct {
@@ -22,6 +49,77 @@ type StatsData struct {
 	LastAccess  string `json:"update_at,omitempty"`
 	CreatedAt   string `json:"created_at,omitempty"`
 	OriginalURL string `json:"original_url,omitempty"`
+	Protected   bool   `json:"password_protected,omitempty"`
+	MaxClicks   int64  `json:"max_clicks,omitempty"`
+	// ClicksRemaining is only set for click-limited links.
+	ClicksRemaining *int64 `json:"clicks_remaining,omitempty"`
+	// Metadata describes the destination page once it has been fetched.
+	Metadata *metadata.Metadata `json:"metadata,omitempty"`
+	// Variants holds the clicks per destination variant, such as
+	// "lang:de" or "ab:v1", of links with several destinations.
+	Variants map[string]int64 `json:"variants,omitempty"`
+}
+
+// ResolveLink returns the cached fields of a link, loading them from the
+// database and caching them on a cache miss.
+func (s *Service) ResolveLink(code string) (*database.CachedURL, error) {
+	if cached, err := s.Redis.HGetAll(code); err == nil && cached["url"] != "" {
+		fields := database.CachedURLFromMap(cached)
+		return &fields, nil
+	}
+
+	u, err := s.Models.URL.GetOne(code)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	fields := CachedFields(u)
+	// storing cache in background
+	s.StoreInRedisCacheBG(u.ShortCode, fields.ToMap(), database.LinkTTL(u.ActiveFrom, u.ExpiresAt))
+
+	return &fields, nil
+}
+
+// CachedFields converts a persisted link to its cache representation.
+func CachedFields(u *data.URL) database.CachedURL {
+	fields := database.CachedURL{
+		URL:       u.OriginalURL,
+		Persisted: "1",
+	}
+	if u.ExpiresAt != nil {
+		fields.ExpiresAt = u.ExpiresAt.Format(time.RFC3339)
+	}
+	if u.ActiveFrom != nil {
+		fields.ActiveFrom = u.ActiveFrom.Format(time.RFC3339)
+		fields.FallbackURL = u.FallbackURL
+	}
+	if u.IsDisabled() {
+		fields.Disabled = "1"
+	}
+	fields.PasswordHash = u.PasswordHash
+	if u.MaxClicks > 0 {
+		fields.MaxClicks = strconv.FormatInt(u.MaxClicks, 10)
+	}
+	if u.RedirectStatus != 0 {
+		fields.RedirectStatus = strconv.Itoa(u.RedirectStatus)
+	}
+	fields.ForwardQuery = u.ForwardQuery
+	if u.ForwardPath {
+		fields.ForwardPath = "1"
+	}
+	fields.QueryParams = database.JSONField(u.QueryParams)
+	fields.Targets = database.JSONField(u.Targets)
+	fields.Languages = database.JSONField(u.Languages)
+	fields.Variants = database.JSONField(u.Variants)
+	fields.Rotation = u.Rotation
+	fields.DeepLink = database.JSONField(u.DeepLink)
+	fields.Interstitial = database.BoolField(u.Interstitial)
+	fields.OpenGraph = database.JSONField(u.OpenGraph)
+	fields.OwnerID = u.OwnerID
+	if u.QueryParamsOverride {
+		fields.QueryOverride = "1"
+	}
+	return fields
 }
 
 func (s *Service) GenerateShortCode() string {
@@ -35,11 +133,96 @@ func (s *Service) GetShortCode() string {
 	return padded
 }
 
-func (s *Service) UpdateHitCountBG(c string) {
+// GenerateShortCodes reserves n counter values with a single INCRBY and
+// returns their codes, so a batch costs one round trip to Redis.
+func (s *Service) GenerateShortCodes(n int) ([]string, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	last, err := s.Redis.INCRBY(int64(n))
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, n)
+	for incr := last - int64(n) + 1; incr <= last; incr++ {
+		padded := fmt.Sprintf("%0*d", utils.ShortCodeLenMin, incr)
+		codes = append(codes, utils.HashToBase62(padded))
+	}
+
+	return codes, nil
+}
+
+// ReserveCode claims a custom alias in the cache before it is persisted,
+// so two requests cannot be handed the same alias. It returns false when the
+// alias is already taken.
+func (s *Service) ReserveCode(code string, fields database.CachedURL, ttl time.Duration) (bool, error) {
+	if _, err := s.Models.URL.GetOne(code); err == nil {
+		return false, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	ok, err := s.Redis.HSetNX(code, "url", fields.URL)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return true, s.Redis.HSet(code, fields.ToMap(), ttl)
+}
+
+// NormalizedHash returns the hash used to find links with an equivalent destination.
+func (s *Service) NormalizedHash(u string) (string, error) {
+	return utils.HashNormalizedURL(u, s.StripTrackingParams)
+}
+
+// FindExistingCode looks up a non-expiring link of owner whose destination
+// normalizes to hash. The cache is checked first because new links are only
+// persisted once the worker has processed them.
+func (s *Service) FindExistingCode(owner, hash string) (string, bool) {
+	if code, err := s.Redis.Get(normalizedKey(owner, hash)); err == nil && code != "" {
+		return code, true
+	}
+
+	u, err := s.Models.URL.GetByNormalizedHash(owner, hash)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("failed to look up normalized hash %s: %v", hash, err)
+		}
+		return "", false
+	}
+
+	s.IndexNormalizedHash(owner, hash, u.ShortCode)
+	return u.ShortCode, true
+}
+
+// IndexNormalizedHash caches the code for a destination hash so later
+// reuse_existing requests of the same owner can find it before it reaches
+// the database.
+func (s *Service) IndexNormalizedHash(owner, hash, code string) {
+	if err := s.Redis.Set(normalizedKey(owner, hash), code); err != nil {
+		log.Printf("failed to index normalized hash for %s: %v", code, err)
+	}
+}
+
+func normalizedKey(owner, hash string) string {
+	return "norm:" + owner + ":" + hash
+}
+
+// UpdateHitCountBG counts a click of link c. A non-empty variant also
+// counts it for the destination variant the visitor was sent to.
+func (s *Service) UpdateHitCountBG(c, variant string) {
 	go func(c string) {
 		const maxRetries = 3
 		const retryDelay = 200 * time.Millisecond
 
+		if variant != "" {
+			if err := s.Models.URL.IncrementVariantClicks(c, variant); err != nil {
+				log.Printf("failed to update variant clicks of %s: %v", c, err)
+			}
+		}
+
 		for attempt := 1; attempt <= maxRetries; attempt++ {
 			err := s.Models.URL.IncrementHitCount(c)
 			if err == nil {
@@ -54,45 +237,111 @@ func (s *Service) UpdateHitCountBG(c string) {
 	}(c)
 }
 
-func (s *Service) StoreInRedisCacheBG(key string, values map[string]string) {
+func (s *Service) StoreInRedisCacheBG(key string, values map[string]string, ttl ...time.Duration) {
 	go func() {
-		if err := s.Redis.HSet(key, values); err != nil {
+		if err := s.Redis.HSet(key, values, ttl...); err != nil {
 			log.Printf("failed to store in redis cache: %v", err)
 		}
 	}()
 }
 
-func (s *Service) GetStats(code string) (*StatsData, error) {
-	cacheKey := "stats:" + code
+// GetStats returns the stats of a link owned by owner. Links of other owners
+// are reported as not found so their existence is not revealed.
+func (s *Service) GetStats(code, owner string) (*StatsData, error) {
+	cacheKey := "stats:" + owner + ":" + code
 
 	if cached, err := s.Redis.Get(cacheKey); err == nil && cached != "" {
-		var stats StatsData
-		if err := json.Unmarshal([]byte(cached), &stats); err == nil {
+		var entry cachedStats
+		if err := json.Unmarshal([]byte(cached), &entry); err == nil {
+			stats := entry.StatsData
+			s.setClicksRemaining(&stats)
+			// Entries cached before HasVariants existed carry the clicks
+			if entry.HasVariants || len(stats.Variants) > 0 {
+				s.setVariantClicks(&stats)
+			}
 			return &stats, nil
 		}
 		// If unmarshal fails, fallback to DB
 	}
 
 	// DB lookup if cache miss
+	u, err := s.Models.URL.GetOne(code)
+	if err != nil || u.OwnerID != owner {
+		return nil, ErrNotFound
+	}
+
+	stats := statsOf(u)
+	hasVariants := len(u.Languages) > 0 || len(u.Variants) > 0
+
+	// Cache result for next time
+	if jsonData, err := json.Marshal(cachedStats{StatsData: *stats, HasVariants: hasVariants}); err == nil {
+		if err := s.Redis.Set(cacheKey, string(jsonData)); err != nil {
+			log.Println("failed to cache stats: ", err)
+		}
+	}
+
+	s.setClicksRemaining(stats)
+	if hasVariants {
+		s.setVariantClicks(stats)
+	}
+	return stats, nil
+}
+
+// cachedStats is the cached form of StatsData. Variant clicks are left out
+// and loaded on every request, as owners follow them during A/B tests.
+type cachedStats struct {
+	StatsData
+	HasVariants bool `json:"has_variants,omitempty"`
+}
+
+// GetPreview returns the stats shown on the public preview page of a link.
+// Unlike GetStats it is not limited to the owner and is not cached, so the
+// page always shows the current click count.
+func (s *Service) GetPreview(code string) (*StatsData, error) {
 	u, err := s.Models.URL.GetOne(code)
 	if err != nil {
-		return nil, errors.New("short code not found")
+		return nil, ErrNotFound
 	}
 
+	return statsOf(u), nil
+}
+
+func statsOf(u *data.URL) *StatsData {
 	stats := &StatsData{
 		Code:        u.ShortCode,
 		Count:       u.HitCount,
 		LastAccess:  u.UpdatedAt.Format("2006-01-02 15:04:05"),
 		CreatedAt:   u.CreatedAt.Format("2006-01-02 15:04:05"),
 		OriginalURL: u.OriginalURL,
+		Protected:   u.IsProtected(),
+		MaxClicks:   u.MaxClicks,
+		Metadata:    u.Metadata,
 	}
+	if u.MaxClicks > 0 {
+		remaining := u.ClicksRemaining()
+		stats.ClicksRemaining = &remaining
+	}
+	return stats
+}
 
-	// Cache result for next time
-	if jsonData, err := json.Marshal(stats); err == nil {
-		if err := s.Redis.Set(cacheKey, string(jsonData)); err != nil {
-			log.Println("failed to cache stats: ", err)
-		}
+// setVariantClicks loads the current clicks per variant of a link with
+// several destinations.
+func (s *Service) setVariantClicks(stats *StatsData) {
+	clicks, err := s.Models.URL.VariantClicks(stats.Code)
+	if err != nil {
+		log.Printf("failed to load variant clicks of %s: %v", stats.Code, err)
+		return
 	}
+	stats.Variants = clicks
+}
 
-	return stats, nil
+// setClicksRemaining refreshes the clicks left from the live counter, since
+// stats are cached for longer than a click-limited link may last.
+func (s *Service) setClicksRemaining(stats *StatsData) {
+	if stats.MaxClicks == 0 || stats.ClicksRemaining == nil {
+		return
+	}
+
+	remaining := s.ClicksRemaining(stats.Code, *stats.ClicksRemaining)
+	stats.ClicksRemaining = &remaining
 }
@@ -0,0 +1,74 @@
+package targeting
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const appURLMaxLength = 2048
+
+var appSchemeRegex = regexp.MustCompile("^[a-z][a-z0-9+.-]*$")
+
+// Schemes that browsers handle themselves and are never an app.
+var nonAppSchemes = map[string]bool{
+	"http":       true,
+	"https":      true,
+	"javascript": true,
+	"data":       true,
+	"vbscript":   true,
+	"file":       true,
+	"blob":       true,
+	"about":      true,
+}
+
+// DeepLink opens a mobile app. Visitors on iOS and Android are sent to
+// AppURL, falling back to the store of their platform when the app is not
+// installed; everyone else gets the web URL of the link.
+type DeepLink struct {
+	AppURL          string `json:"app_url"`
+	IOSStoreURL     string `json:"ios_store_url,omitempty"`
+	AndroidStoreURL string `json:"android_store_url,omitempty"`
+}
+
+// Validate checks the app URL.
+func (d *DeepLink) Validate() error {
+	if d.AppURL == "" {
+		return errors.New("deep_link.app_url is required")
+	}
+	if len(d.AppURL) > appURLMaxLength {
+		return errors.New("deep_link.app_url is too long")
+	}
+
+	u, err := url.Parse(d.AppURL)
+	if err != nil || !appSchemeRegex.MatchString(u.Scheme) {
+		return errors.New("deep_link.app_url must be an app URL such as myapp://item/42")
+	}
+	if nonAppSchemes[u.Scheme] {
+		return errors.New("deep_link.app_url must use the scheme of an app, not " + u.Scheme)
+	}
+	if strings.ContainsAny(d.AppURL, " \t\r\n") {
+		return errors.New("deep_link.app_url must not contain whitespace")
+	}
+
+	return nil
+}
+
+// OpensApp reports whether visitors with ua should be sent to the app.
+func (d *DeepLink) OpensApp(ua UserAgent) bool {
+	return ua.Device != DeviceBot && (ua.OS == OSiOS || ua.OS == OSAndroid)
+}
+
+// StoreURL returns the store page of the app for os, or "" when the link
+// has none.
+func (d *DeepLink) StoreURL(os string) string {
+	switch os {
+	case OSiOS:
+		return d.IOSStoreURL
+	case OSAndroid:
+		return d.AndroidStoreURL
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,141 @@
+package targeting
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const LanguagesMax = 50
+
+// LanguageTagLenMax is the practical maximum length of a BCP 47 tag. It
+// also keeps the click counter of each language within its column.
+const LanguageTagLenMax = 35
+
+var languageTagRegex = regexp.MustCompile("^[A-Za-z]{2,3}(-[A-Za-z0-9]{1,8})*$")
+
+// LanguageRange is one entry of an Accept-Language header.
+type LanguageRange struct {
+	Tag string
+	Q   float64
+}
+
+// ParseAcceptLanguage returns the ranges of an Accept-Language header, most
+// preferred first. Ranges with q=0 are not acceptable and are left out, as
+// are malformed entries. Ranges of equal quality keep their header order.
+func ParseAcceptLanguage(header string) []LanguageRange {
+	var ranges []LanguageRange
+
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || (tag != "*" && !languageTagRegex.MatchString(tag)) {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				continue
+			}
+			q = parsed
+		}
+		if q == 0 {
+			continue
+		}
+
+		ranges = append(ranges, LanguageRange{Tag: tag, Q: q})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].Q > ranges[j].Q
+	})
+
+	return ranges
+}
+
+// NegotiateLanguage returns the tag of languages that best matches the
+// Accept-Language header. Ranges are tried in order of preference: first
+// the range and its shorter prefixes ("de-AT" also matches "de"), then
+// variants of the range ("fr" also matches "fr-CA"). "*" matches nothing,
+// leaving the choice to the default.
+func NegotiateLanguage(languages map[string]string, header string) (string, bool) {
+	if len(languages) == 0 || header == "" {
+		return "", false
+	}
+
+	tags := make(map[string]string, len(languages))
+	for tag := range languages {
+		tags[strings.ToLower(tag)] = tag
+	}
+
+	for _, r := range ParseAcceptLanguage(header) {
+		if r.Tag == "*" {
+			continue
+		}
+
+		for candidate := r.Tag; candidate != ""; candidate = truncateTag(candidate) {
+			if tag, ok := tags[candidate]; ok {
+				return tag, true
+			}
+		}
+
+		var variants []string
+		for lower, tag := range tags {
+			if strings.HasPrefix(lower, r.Tag+"-") {
+				variants = append(variants, tag)
+			}
+		}
+		if len(variants) > 0 {
+			return slices.Min(variants), true
+		}
+	}
+
+	return "", false
+}
+
+// ValidateLanguages checks that every key of languages is a language tag.
+func ValidateLanguages(languages map[string]string) error {
+	if len(languages) > LanguagesMax {
+		return fmt.Errorf("languages may contain at most %d entries", LanguagesMax)
+	}
+
+	seen := make(map[string]bool, len(languages))
+	for tag, url := range languages {
+		if len(tag) > LanguageTagLenMax {
+			return fmt.Errorf("languages: tags must be at most %d characters", LanguageTagLenMax)
+		}
+		if !languageTagRegex.MatchString(tag) {
+			return fmt.Errorf("languages: %q is not a language tag", tag)
+		}
+		if seen[strings.ToLower(tag)] {
+			return fmt.Errorf("languages: %q is listed more than once", tag)
+		}
+		seen[strings.ToLower(tag)] = true
+
+		if url == "" {
+			return fmt.Errorf("languages: %q must have a url", tag)
+		}
+	}
+
+	return nil
+}
+
+// truncateTag drops the last subtag, and any single-letter subtag left in
+// front of it, as in RFC 4647 lookup.
+func truncateTag(tag string) string {
+	i := strings.LastIndex(tag, "-")
+	if i < 0 {
+		return ""
+	}
+
+	tag = tag[:i]
+	if j := strings.LastIndex(tag, "-"); j >= 0 && len(tag)-j == 2 {
+		tag = tag[:j]
+	}
+	return tag
+}
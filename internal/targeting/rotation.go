@@ -0,0 +1,126 @@
+package targeting
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"regexp"
+)
+
+const (
+	VariantsMax      = 10
+	VariantWeightMax = 1000
+)
+
+// Rotation modes decide how a visitor is assigned a variant. RotationRandom
+// picks on every visit, RotationCookie remembers the first pick in a cookie
+// and RotationIP derives it from a hash of the client IP.
+const (
+	RotationRandom = "random"
+	RotationCookie = "cookie"
+	RotationIP     = "ip"
+)
+
+var variantNameRegex = regexp.MustCompile("^[A-Za-z0-9_-]{1,32}$")
+
+// Variant is one of several destinations a link rotates between. Visitors
+// are sent to it in proportion to Weight.
+type Variant struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Weight int    `json:"weight"`
+}
+
+// SetVariantDefaults names unnamed variants after their position ("v1",
+// "v2", ...) and gives a weight of 1 to those without one.
+func SetVariantDefaults(variants []Variant) {
+	for i := range variants {
+		if variants[i].Name == "" {
+			variants[i].Name = fmt.Sprintf("v%d", i+1)
+		}
+		if variants[i].Weight == 0 {
+			variants[i].Weight = 1
+		}
+	}
+}
+
+// ValidateVariants checks the variants of a link and its rotation mode.
+func ValidateVariants(variants []Variant, rotation string) error {
+	if len(variants) == 0 {
+		if rotation != "" {
+			return errors.New("rotation requires variants")
+		}
+		return nil
+	}
+
+	switch rotation {
+	case "", RotationRandom, RotationCookie, RotationIP:
+	default:
+		return fmt.Errorf("rotation must be one of %q, %q or %q", RotationRandom, RotationCookie, RotationIP)
+	}
+
+	if len(variants) < 2 || len(variants) > VariantsMax {
+		return fmt.Errorf("variants must contain between 2 and %d entries", VariantsMax)
+	}
+
+	seen := make(map[string]bool, len(variants))
+	for i, variant := range variants {
+		if !variantNameRegex.MatchString(variant.Name) {
+			return fmt.Errorf("variants[%d].name must be 1-32 letters, digits, '-' or '_'", i)
+		}
+		if seen[variant.Name] {
+			return fmt.Errorf("variants[%d].name %q is used more than once", i, variant.Name)
+		}
+		seen[variant.Name] = true
+
+		if variant.URL == "" {
+			return fmt.Errorf("variants[%d] must have a url", i)
+		}
+		if variant.Weight < 1 || variant.Weight > VariantWeightMax {
+			return fmt.Errorf("variants[%d].weight must be between 1 and %d", i, VariantWeightMax)
+		}
+	}
+
+	return nil
+}
+
+// PickRandom chooses a variant at random by weight.
+func PickRandom(variants []Variant) Variant {
+	return pick(variants, rand.Uint64())
+}
+
+// PickByKey chooses a variant by weight from a hash of key, so the same key
+// always gets the same variant as long as the variants do not change.
+func PickByKey(variants []Variant, key string) Variant {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return pick(variants, h.Sum64())
+}
+
+// FindVariant returns the variant called name.
+func FindVariant(variants []Variant, name string) (Variant, bool) {
+	for _, variant := range variants {
+		if variant.Name == name {
+			return variant, true
+		}
+	}
+	return Variant{}, false
+}
+
+func pick(variants []Variant, n uint64) Variant {
+	var total uint64
+	for _, variant := range variants {
+		total += uint64(variant.Weight)
+	}
+
+	n %= total
+	for _, variant := range variants {
+		if n < uint64(variant.Weight) {
+			return variant
+		}
+		n -= uint64(variant.Weight)
+	}
+
+	return variants[len(variants)-1]
+}
@@ -0,0 +1,125 @@
+package targeting
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+)
+
+const RulesMax = 20
+
+// Rule sends visitors matching every one of its conditions to URL. An empty
+// condition matches everyone; a condition listing several values matches any
+// of them.
+type Rule struct {
+	OS      []string `json:"os,omitempty"`
+	Device  []string `json:"device,omitempty"`
+	Browser []string `json:"browser,omitempty"`
+
+	// Country holds ISO 3166-1 codes such as "DE" and Region ISO 3166-2
+	// codes such as "US-CA".
+	Country []string `json:"country,omitempty"`
+	Region  []string `json:"region,omitempty"`
+
+	URL string `json:"url"`
+}
+
+var countryRegex = regexp.MustCompile("^[A-Z]{2}$")
+var regionRegex = regexp.MustCompile("^[A-Z]{2}-[A-Z0-9]{1,3}$")
+
+// Visitor holds what rules are matched against. Country and Region are
+// empty when the location of the visitor is unknown.
+type Visitor struct {
+	UserAgent
+	Country string
+	Region  string
+}
+
+// VisitorFromRequest describes the visitor making r, leaving the location
+// to the caller.
+func VisitorFromRequest(r *http.Request) Visitor {
+	return Visitor{
+		UserAgent: ParseUserAgent(r.UserAgent()),
+	}
+}
+
+func (rule Rule) Matches(v Visitor) bool {
+	return matches(rule.OS, v.OS) && matches(rule.Device, v.Device) && matches(rule.Browser, v.Browser) &&
+		matches(rule.Country, v.Country) && matches(rule.Region, v.Region)
+}
+
+// UsesLocation reports whether any rule depends on the visitor's location.
+func UsesLocation(rules []Rule) bool {
+	for _, rule := range rules {
+		if len(rule.Country) > 0 || len(rule.Region) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the URL of the first rule matching v.
+func Match(rules []Rule, v Visitor) (string, bool) {
+	for _, rule := range rules {
+		if rule.Matches(v) {
+			return rule.URL, true
+		}
+	}
+	return "", false
+}
+
+// Validate checks that rules only use known values.
+func Validate(rules []Rule) error {
+	if len(rules) > RulesMax {
+		return fmt.Errorf("targets may contain at most %d rules", RulesMax)
+	}
+
+	for i, rule := range rules {
+		if rule.URL == "" {
+			return fmt.Errorf("targets[%d] must have a url", i)
+		}
+		if len(rule.OS)+len(rule.Device)+len(rule.Browser)+len(rule.Country)+len(rule.Region) == 0 {
+			return fmt.Errorf("targets[%d] must have at least one condition", i)
+		}
+		if err := checkValues(i, "os", rule.OS, knownOS); err != nil {
+			return err
+		}
+		if err := checkValues(i, "device", rule.Device, knownDevices); err != nil {
+			return err
+		}
+		if err := checkValues(i, "browser", rule.Browser, knownBrowsers); err != nil {
+			return err
+		}
+		if err := checkFormat(i, "country", rule.Country, countryRegex); err != nil {
+			return err
+		}
+		if err := checkFormat(i, "region", rule.Region, regionRegex); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func checkValues(i int, field string, values []string, known map[string]bool) error {
+	for _, value := range values {
+		if !known[value] {
+			return fmt.Errorf("targets[%d].%s: unknown value %q", i, field, value)
+		}
+	}
+	return nil
+}
+
+func checkFormat(i int, field string, values []string, format *regexp.Regexp) error {
+	for _, value := range values {
+		if !format.MatchString(value) {
+			return fmt.Errorf("targets[%d].%s: %q is not an upper case ISO 3166 code", i, field, value)
+		}
+	}
+	return nil
+}
+
+func matches(condition []string, value string) bool {
+	return len(condition) == 0 || slices.Contains(condition, value)
+}
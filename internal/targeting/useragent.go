@@ -0,0 +1,150 @@
+package targeting
+
+import (
+	"strings"
+)
+
+// Operating systems, device classes and browsers recognised in User-Agent
+// headers.
+const (
+	OSiOS      = "ios"
+	OSAndroid  = "android"
+	OSWindows  = "windows"
+	OSMacOS    = "macos"
+	OSLinux    = "linux"
+	OSChromeOS = "chromeos"
+	OSOther    = "other"
+
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+
+	BrowserChrome  = "chrome"
+	BrowserSafari  = "safari"
+	BrowserFirefox = "firefox"
+	BrowserEdge    = "edge"
+	BrowserOpera   = "opera"
+	BrowserSamsung = "samsung"
+	BrowserOther   = "other"
+)
+
+var knownOS = map[string]bool{
+	OSiOS: true, OSAndroid: true, OSWindows: true, OSMacOS: true, OSLinux: true, OSChromeOS: true, OSOther: true,
+}
+
+var knownDevices = map[string]bool{
+	DeviceMobile: true, DeviceTablet: true, DeviceDesktop: true, DeviceBot: true,
+}
+
+var knownBrowsers = map[string]bool{
+	BrowserChrome: true, BrowserSafari: true, BrowserFirefox: true, BrowserEdge: true,
+	BrowserOpera: true, BrowserSamsung: true, BrowserOther: true,
+}
+
+var botMarkers = []string{"bot", "crawler", "spider", "slurp", "facebookexternalhit", "headless"}
+
+// unfurlerMarkers identify the crawlers that build link previews in chat
+// apps and social networks, matched case-insensitively.
+var unfurlerMarkers = []string{
+	"slackbot", "slack-imgproxy", "twitterbot", "facebookexternalhit", "facebot", "linkedinbot",
+	"discordbot", "telegrambot", "whatsapp", "skypeuripreview", "pinterestbot", "redditbot",
+	"mastodon", "embedly", "iframely", "vkshare", "bitrix link preview", "microsoftpreview",
+}
+
+// UserAgent is the coarse classification of a User-Agent header used by
+// targeting rules.
+type UserAgent struct {
+	OS      string
+	Device  string
+	Browser string
+}
+
+// ParseUserAgent classifies a User-Agent header. It only looks for the
+// markers that tell the common platforms apart, and anything it does not
+// recognise is reported as "other".
+func ParseUserAgent(ua string) UserAgent {
+	return UserAgent{
+		OS:      parseOS(ua),
+		Device:  parseDevice(ua),
+		Browser: parseBrowser(ua),
+	}
+}
+
+// IsUnfurler reports whether ua belongs to a link preview crawler, which
+// should be shown a link's Open Graph tags instead of being redirected.
+func IsUnfurler(ua string) bool {
+	lower := strings.ToLower(ua)
+	for _, marker := range unfurlerMarkers {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseOS(ua string) string {
+	switch {
+	case containsAny(ua, "iPhone", "iPad", "iPod"):
+		return OSiOS
+	case strings.Contains(ua, "Android"):
+		return OSAndroid
+	case strings.Contains(ua, "CrOS"):
+		return OSChromeOS
+	case strings.Contains(ua, "Windows"):
+		return OSWindows
+	case containsAny(ua, "Macintosh", "Mac OS X"):
+		return OSMacOS
+	case strings.Contains(ua, "Linux"):
+		return OSLinux
+	default:
+		return OSOther
+	}
+}
+
+func parseDevice(ua string) string {
+	lower := strings.ToLower(ua)
+	for _, marker := range botMarkers {
+		if strings.Contains(lower, marker) {
+			return DeviceBot
+		}
+	}
+
+	switch {
+	case containsAny(ua, "iPad", "Tablet"),
+		strings.Contains(ua, "Android") && !strings.Contains(ua, "Mobile"):
+		return DeviceTablet
+	case containsAny(ua, "Mobi", "iPhone", "iPod"):
+		return DeviceMobile
+	default:
+		return DeviceDesktop
+	}
+}
+
+func parseBrowser(ua string) string {
+	switch {
+	case containsAny(ua, "Edg/", "Edge/", "EdgA/", "EdgiOS/"):
+		return BrowserEdge
+	case containsAny(ua, "OPR/", "Opera"):
+		return BrowserOpera
+	case strings.Contains(ua, "SamsungBrowser"):
+		return BrowserSamsung
+	case containsAny(ua, "Firefox/", "FxiOS/"):
+		return BrowserFirefox
+	case containsAny(ua, "Chrome/", "CriOS/"):
+		return BrowserChrome
+	case strings.Contains(ua, "Safari/"):
+		return BrowserSafari
+	default:
+		return BrowserOther
+	}
+}
+
+func containsAny(s string, substrs ...string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
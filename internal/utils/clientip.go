@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIPResolver determines the client address of a request. The
+// X-Forwarded-For header is only trusted when the request arrives from one
+// of the configured proxy networks, so clients cannot spoof their address.
+type ClientIPResolver struct {
+	TrustedProxies []*net.IPNet
+}
+
+// NewClientIPResolver parses a comma separated list of CIDRs or single IPs.
+// Invalid entries are logged and skipped.
+func NewClientIPResolver(trusted string) *ClientIPResolver {
+	resolver := &ClientIPResolver{}
+
+	for _, entry := range strings.Split(trusted, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("ignoring invalid trusted proxy %q: %v", entry, err)
+			continue
+		}
+		resolver.TrustedProxies = append(resolver.TrustedProxies, network)
+	}
+
+	return resolver
+}
+
+// ClientIP returns the address of the client that made the request. Starting
+// from the direct peer, X-Forwarded-For hops are walked from right to left
+// while they belong to trusted proxies; the first untrusted hop is the client.
+func (c *ClientIPResolver) ClientIP(r *http.Request) string {
+	ip := remoteIP(r.RemoteAddr)
+	if !c.isTrusted(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+
+		ip = hop
+		if !c.isTrusted(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func (c *ClientIPResolver) isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range c.TrustedProxies {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
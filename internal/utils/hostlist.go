@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"bufio"
+	"golang.org/x/net/idna"
+	"io"
+	"os"
+	"strings"
+)
+
+// HostList matches hostnames against exact entries such as "example.com" and
+// wildcard entries such as "*.example.com", which match any subdomain but not
+// the apex domain itself.
+type HostList struct {
+	exact    map[string]bool
+	suffixes []string
+}
+
+func NewHostList(entries []string) *HostList {
+	list := &HostList{exact: make(map[string]bool)}
+	for _, entry := range entries {
+		list.Add(entry)
+	}
+	return list
+}
+
+// LoadHostList reads one host per line from path. Blank lines and lines
+// starting with '#' are ignored.
+func LoadHostList(path string) (*HostList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseHostList(f)
+}
+
+func ParseHostList(r io.Reader) (*HostList, error) {
+	list := NewHostList(nil)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list.Add(line)
+	}
+
+	return list, scanner.Err()
+}
+
+func (h *HostList) Add(entry string) {
+	entry = strings.TrimSpace(entry)
+	if entry == "" {
+		return
+	}
+
+	if suffix, ok := strings.CutPrefix(entry, "*."); ok {
+		h.suffixes = append(h.suffixes, "."+CanonicalHost(suffix))
+		return
+	}
+
+	h.exact[CanonicalHost(entry)] = true
+}
+
+// Contains reports whether host matches an entry of the list.
+func (h *HostList) Contains(host string) bool {
+	if h == nil {
+		return false
+	}
+
+	host = CanonicalHost(host)
+	if h.exact[host] {
+		return true
+	}
+
+	for _, suffix := range h.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (h *HostList) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.exact) + len(h.suffixes)
+}
+
+// CanonicalHost lowercases host, drops a trailing dot and converts
+// internationalized names to their punycode form so equivalent spellings
+// compare equal.
+func CanonicalHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		return ascii
+	}
+	return host
+}
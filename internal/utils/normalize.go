@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// trackingParams are query parameters that identify where a click came from
+// rather than what it points to, so they can be dropped when comparing URLs.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"yclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"_ga":     true,
+}
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// NormalizeURL rewrites u into a canonical form so that equivalent URLs
+// compare equal: scheme and host are lowercased, default ports and empty
+// paths are normalized and query parameters are sorted. When stripTracking
+// is set, utm_* and well-known click id parameters are removed as well.
+func NormalizeURL(u string, stripTracking bool) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(u))
+	if err != nil {
+		return "", err
+	}
+
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+
+	host := strings.ToLower(parsed.Hostname())
+	if port := parsed.Port(); port != "" && port != defaultPorts[parsed.Scheme] {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// IPv6 literal without a port still needs its brackets
+		host = "[" + host + "]"
+	}
+	parsed.Host = host
+
+	if parsed.Path == "" {
+		parsed.Path = "/"
+	}
+
+	query := parsed.Query()
+	if stripTracking {
+		for key := range query {
+			if isTrackingParam(key) {
+				query.Del(key)
+			}
+		}
+	}
+	// Encode sorts by key, which gives a stable order
+	parsed.RawQuery = query.Encode()
+
+	return parsed.String(), nil
+}
+
+// HashNormalizedURL returns the hex encoded SHA-256 of the normalized form of u.
+func HashNormalizedURL(u string, stripTracking bool) (string, error) {
+	normalized, err := NormalizeURL(u, stripTracking)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func isTrackingParam(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasPrefix(key, "utm_") || trackingParams[key]
+}
@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Modes for forwarding the query string of a short link request to the
+// destination. They differ in how keys present on both are merged.
+const (
+	ForwardQueryAppend  = "append"  // keep both, destination values first
+	ForwardQueryKeep    = "keep"    // the destination's values win
+	ForwardQueryReplace = "replace" // the request's values win
+)
+
+var ErrUnsafePath = errors.New("path cannot be forwarded")
+
+const QueryParamsMax = 20
+const queryParamLenMax = 256
+
+func ValidateForwardQuery(mode string) error {
+	switch mode {
+	case "", ForwardQueryAppend, ForwardQueryKeep, ForwardQueryReplace:
+		return nil
+	}
+	return errors.New("forward_query must be one of append, keep or replace")
+}
+
+// ForwardQuery merges incoming into the query of dest according to mode.
+// dest is left untouched when there is nothing to forward.
+func ForwardQuery(dest *url.URL, incoming url.Values, mode string) {
+	if len(incoming) == 0 {
+		return
+	}
+
+	query := dest.Query()
+	for key, values := range incoming {
+		switch mode {
+		case ForwardQueryKeep:
+			if _, ok := query[key]; !ok {
+				query[key] = values
+			}
+		case ForwardQueryReplace:
+			query[key] = values
+		default:
+			query[key] = append(query[key], values...)
+		}
+	}
+
+	dest.RawQuery = query.Encode()
+}
+
+// ForwardPath appends rest, an escaped path such as "docs/intro", below the
+// path of dest. Each segment is unescaped and checked on its own, so dot
+// segments, encoded slashes and backslashes cannot climb out of the
+// destination path, and the scheme and host of dest never change.
+func ForwardPath(dest *url.URL, rest string) (*url.URL, error) {
+	segments := strings.Split(rest, "/")
+	escaped := make([]string, 0, len(segments))
+
+	for i, segment := range segments {
+		// Keep a single trailing slash
+		if segment == "" && i == len(segments)-1 && i > 0 {
+			escaped[i-1] += "/"
+			continue
+		}
+
+		s, err := url.PathUnescape(segment)
+		if err != nil || s == "" || s == "." || s == ".." || strings.ContainsAny(s, "/\\") {
+			return nil, ErrUnsafePath
+		}
+		escaped = append(escaped, url.PathEscape(s))
+	}
+
+	return dest.JoinPath(escaped...), nil
+}
+
+// ValidateQueryParams checks the query parameter templates of a link.
+func ValidateQueryParams(params map[string]string) error {
+	if len(params) > QueryParamsMax {
+		return fmt.Errorf("query_params may contain at most %d parameters", QueryParamsMax)
+	}
+
+	for key, value := range params {
+		if key == "" || len(key) > queryParamLenMax || len(value) > queryParamLenMax {
+			return fmt.Errorf("query_params keys must be 1 to %d and values up to %d characters", queryParamLenMax, queryParamLenMax)
+		}
+	}
+
+	return nil
+}
+
+// ApplyQueryParams adds the query parameter templates of a link to dest,
+// replacing {code} and {date} in their values. Parameters dest already has
+// are preserved. With override, values of incoming for the same key replace
+// the stored ones.
+func ApplyQueryParams(dest *url.URL, params map[string]string, code string, incoming url.Values, override bool) {
+	if len(params) == 0 {
+		return
+	}
+
+	placeholders := strings.NewReplacer(
+		"{code}", code,
+		"{date}", time.Now().UTC().Format(time.DateOnly),
+	)
+
+	query := dest.Query()
+	for key, value := range params {
+		if _, ok := query[key]; ok {
+			continue
+		}
+
+		if values, ok := incoming[key]; ok && override {
+			query[key] = values
+			continue
+		}
+
+		query.Set(key, placeholders.Replace(value))
+	}
+
+	dest.RawQuery = query.Encode()
+}
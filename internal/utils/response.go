@@ -9,9 +9,26 @@ import (
 
 // JsonResponse defines the standard structure for all JSON responses from the server.
 type JsonResponse struct {
-	Error   bool   `json:"error"`          // Indicates if the response represents an error (true = error, false = success)
-	Message string `json:"message"`        // A human-readable message about the response (success message or error description)
-	Data    any    `json:"data,omitempty"` // Optional field for sending additional data (empty if not needed)
+	Error     bool   `json:"error"`                // Indicates if the response represents an error (true = error, false = success)
+	ErrorCode string `json:"error_code,omitempty"` // Optional machine-readable code identifying the error
+	Message   string `json:"message"`              // A human-readable message about the response (success message or error description)
+	Data      any    `json:"data,omitempty"`       // Optional field for sending additional data (empty if not needed)
+}
+
+// CodedError is implemented by errors that carry a machine-readable code,
+// which ErrorJSON includes in the response.
+type CodedError interface {
+	error
+	ErrorCode() string
+}
+
+// ErrorCodeOf returns the code carried by err, or an empty string.
+func ErrorCodeOf(err error) string {
+	var coded CodedError
+	if errors.As(err, &coded) {
+		return coded.ErrorCode()
+	}
+	return ""
 }
 
 type Response struct{}
@@ -86,6 +103,7 @@ func (res *Response) ErrorJSON(w http.ResponseWriter, err error, status ...int)
 	// Create the payload with error information
 	var payload JsonResponse
 	payload.Error = true
+	payload.ErrorCode = ErrorCodeOf(err)
 	payload.Message = err.Error()
 
 	// Write the payload as JSON to the response writer
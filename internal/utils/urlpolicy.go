@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"fmt"
+	"golang.org/x/net/idna"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Error codes reported when a destination URL violates the policy.
+const (
+	CodeURLRequired           = "url_required"
+	CodeURLTooLong            = "url_too_long"
+	CodeURLInvalid            = "url_invalid"
+	CodeSchemeNotAllowed      = "scheme_not_allowed"
+	CodeHostMissing           = "host_missing"
+	CodeCredentialsNotAllowed = "credentials_not_allowed"
+	CodeHostBlocked           = "host_blocked"
+	CodeHostNotAllowed        = "host_not_allowed"
+	CodeIDNNotAllowed         = "idn_not_allowed"
+	CodeIDNInvalid            = "idn_invalid"
+	CodeHomographSuspected    = "homograph_suspected"
+)
+
+const defaultURLMaxLength = 2048
+const defaultURLAllowedSchemes = "http,https"
+
+// PolicyError describes which rule a URL broke. Code is stable and meant for
+// clients; Message is human readable.
+type PolicyError struct {
+	Code    string
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Message
+}
+
+func (e *PolicyError) ErrorCode() string {
+	return e.Code
+}
+
+// NewPolicyError builds a *PolicyError with a formatted message.
+func NewPolicyError(code, format string, args ...any) error {
+	return &PolicyError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// URLPolicy decides which destinations may be shortened.
+type URLPolicy struct {
+	AllowedSchemes   map[string]bool
+	MaxLength        int
+	BlockedHosts     *HostList
+	AllowedHosts     *HostList // when non-empty, only these hosts are accepted
+	AllowCredentials bool
+	AllowIDN         bool
+}
+
+func DefaultURLPolicy() *URLPolicy {
+	return &URLPolicy{
+		AllowedSchemes: parseSchemes(defaultURLAllowedSchemes),
+		MaxLength:      defaultURLMaxLength,
+		AllowIDN:       true,
+	}
+}
+
+var urlPolicy = DefaultURLPolicy()
+
+// SetURLPolicy replaces the policy used by ValidateOriginalURL.
+func SetURLPolicy(p *URLPolicy) {
+	urlPolicy = p
+}
+
+// LoadURLPolicyFromEnv builds the policy from URL_* environment variables.
+func LoadURLPolicyFromEnv() (*URLPolicy, error) {
+	p := DefaultURLPolicy()
+
+	if schemes := os.Getenv("URL_ALLOWED_SCHEMES"); schemes != "" {
+		p.AllowedSchemes = parseSchemes(schemes)
+	}
+	p.MaxLength = GetEnvInt("URL_MAX_LENGTH", defaultURLMaxLength)
+	p.AllowCredentials = GetEnvBool("URL_ALLOW_CREDENTIALS")
+	if v := os.Getenv("URL_ALLOW_IDN"); v != "" {
+		p.AllowIDN = GetEnvBool("URL_ALLOW_IDN")
+	}
+
+	if path := os.Getenv("URL_BLOCKED_HOSTS_FILE"); path != "" {
+		list, err := LoadHostList(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading blocked hosts: %w", err)
+		}
+		log.Printf("Loaded %d blocked hosts from %s", list.Len(), path)
+		p.BlockedHosts = list
+	}
+
+	if path := os.Getenv("URL_ALLOWED_HOSTS_FILE"); path != "" {
+		list, err := LoadHostList(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading allowed hosts: %w", err)
+		}
+		log.Printf("Loaded %d allowed hosts from %s", list.Len(), path)
+		p.AllowedHosts = list
+	}
+
+	return p, nil
+}
+
+// Validate checks u against every rule of the policy and returns a
+// *PolicyError for the first rule it breaks.
+func (p *URLPolicy) Validate(u string) error {
+	if u == "" {
+		return NewPolicyError(CodeURLRequired, "url is required")
+	}
+
+	if len(u) > p.MaxLength {
+		return NewPolicyError(CodeURLTooLong, "url must be at most %d characters", p.MaxLength)
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return NewPolicyError(CodeURLInvalid, "url is invalid")
+	}
+
+	scheme := strings.ToLower(parsed.Scheme)
+	if scheme == "" {
+		return NewPolicyError(CodeURLInvalid, "url must be absolute")
+	}
+	if !p.AllowedSchemes[scheme] {
+		return NewPolicyError(CodeSchemeNotAllowed, "url scheme %q is not allowed", scheme)
+	}
+
+	host := parsed.Hostname()
+	if host == "" {
+		return NewPolicyError(CodeHostMissing, "url must include a host")
+	}
+
+	if parsed.User != nil && !p.AllowCredentials {
+		return NewPolicyError(CodeCredentialsNotAllowed, "url must not contain credentials")
+	}
+
+	if err := p.checkIDN(host); err != nil {
+		return err
+	}
+
+	if p.BlockedHosts.Contains(host) {
+		return NewPolicyError(CodeHostBlocked, "url host is blocked")
+	}
+
+	if p.AllowedHosts.Len() > 0 && !p.AllowedHosts.Contains(host) {
+		return NewPolicyError(CodeHostNotAllowed, "url host is not allowed")
+	}
+
+	return nil
+}
+
+// checkIDN rejects internationalized hosts that are invalid or that look
+// like an attempt to imitate another domain.
+func (p *URLPolicy) checkIDN(host string) error {
+	lower := strings.ToLower(host)
+	if isASCII(lower) && !strings.Contains(lower, "xn--") {
+		return nil
+	}
+
+	if !p.AllowIDN {
+		return NewPolicyError(CodeIDNNotAllowed, "internationalized domain names are not allowed")
+	}
+
+	unicodeHost, err := idna.Lookup.ToUnicode(lower)
+	if err != nil {
+		return NewPolicyError(CodeIDNInvalid, "url host is not a valid internationalized domain name")
+	}
+
+	labels := strings.Split(strings.TrimSuffix(unicodeHost, "."), ".")
+	tld := labels[len(labels)-1]
+	for _, label := range labels {
+		if isHomograph(label, tld) {
+			return NewPolicyError(CodeHomographSuspected, "url host %q may be imitating another domain", unicodeHost)
+		}
+	}
+
+	return nil
+}
+
+// confusableScripts are the scripts whose letters are most often mistaken
+// for Latin ones.
+var confusableScripts = []*unicode.RangeTable{unicode.Cyrillic, unicode.Greek}
+
+// latinLookalikes are Cyrillic and Greek letters that render like Latin
+// letters in common fonts.
+const latinLookalikes = "аеорсухіјѕԁһӏԛԝвкмнтьαοινκρτυχ"
+
+// isHomograph reports whether label mixes Latin with Cyrillic or Greek
+// letters, or is spelled entirely with Latin lookalikes under a TLD that is
+// not written in that script.
+func isHomograph(label, tld string) bool {
+	latin, confusable := false, false
+	lookalikesOnly := true
+
+	for _, r := range label {
+		switch {
+		case r == '-' || unicode.IsDigit(r):
+			continue
+		case unicode.Is(unicode.Latin, r):
+			latin = true
+		case unicode.In(r, confusableScripts...):
+			confusable = true
+		}
+
+		if !strings.ContainsRune(latinLookalikes, r) {
+			lookalikesOnly = false
+		}
+	}
+
+	if latin && confusable {
+		return true
+	}
+
+	return confusable && lookalikesOnly && isASCII(tld)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func parseSchemes(list string) map[string]bool {
+	schemes := make(map[string]bool)
+	for _, scheme := range strings.Split(list, ",") {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			schemes[scheme] = true
+		}
+	}
+	return schemes
+}
@@ -4,46 +4,133 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"errors"
-	"net/url"
+	"fmt"
+	"os"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
 var base62Regex = regexp.MustCompile("^[a-zA-Z0-9]+$")
+var aliasRegex = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
 
 const ShortCodeLenMin = 7
 const ShortCodeLenMax = 8
 
-func ValidateOriginalURL(u string) error {
-	if u == "" {
-		return errors.New("url is required")
-	}
+const AliasLenMin = 4
+const AliasLenMax = 32
 
-	_, err := url.ParseRequestURI(u)
-	if err != nil {
-		return err
-	}
+const LinkPasswordLenMin = 4
 
-	return nil
+// LinkPasswordLenMax is the longest password bcrypt can hash without
+// silently truncating it.
+const LinkPasswordLenMax = 72
+
+// reservedAliases are paths already served by the router, so an alias with
+// one of these names would never be reachable.
+var reservedAliases = map[string]bool{
+	"ping":    true,
+	"stats":   true,
+	"shorten": true,
+	"preview": true,
+	"admin":   true,
+
+	"apple-app-site-association": true,
 }
 
+// ValidateOriginalURL checks a destination against the configured URLPolicy.
+// Violations are returned as *PolicyError.
+func ValidateOriginalURL(u string) error {
+	return urlPolicy.Validate(u)
+}
+
+// ValidateShortCode accepts both generated codes and custom aliases, since
+// either can be used to look up a link.
 func ValidateShortCode(code string) error {
 	if code == "" {
 		return errors.New("code is required")
 	}
 
-	if !IsBase62(code) {
-		return errors.New("code is invalid")
+	if IsBase62(code) && IsLengthOk(code) {
+		return nil
+	}
+
+	if ValidateAlias(code) == nil {
+		return nil
+	}
+
+	return errors.New("code is invalid")
+}
+
+func ValidateAlias(alias string) error {
+	if len(alias) < AliasLenMin || len(alias) > AliasLenMax {
+		return fmt.Errorf("alias must be between %d and %d characters", AliasLenMin, AliasLenMax)
+	}
+
+	if !aliasRegex.MatchString(alias) {
+		return errors.New("alias may only contain letters, digits, '-' and '_'")
+	}
+
+	if reservedAliases[strings.ToLower(alias)] {
+		return errors.New("alias is reserved")
+	}
+
+	// Generated codes are 7-8 letters and digits; an alias of that shape
+	// could later be handed out as a code, or claim one already in use
+	if IsBase62(alias) && IsLengthOk(alias) {
+		return fmt.Errorf("alias of %d-%d letters and digits only is reserved for generated codes; use another length or add '-' or '_'", ShortCodeLenMin, ShortCodeLenMax)
 	}
 
-	if !IsLengthOk(code) {
-		return errors.New("code is invalid")
+	return nil
+}
+
+func ValidateLinkPassword(password string) error {
+	if len(password) < LinkPasswordLenMin || len(password) > LinkPasswordLenMax {
+		return fmt.Errorf("password must be between %d and %d bytes", LinkPasswordLenMin, LinkPasswordLenMax)
 	}
 
 	return nil
 }
 
+// ParseExpiry parses an optional RFC3339 expiry. An empty string means the
+// link never expires and yields a nil time.
+func ParseExpiry(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, errors.New("expires_at must be an RFC3339 timestamp")
+	}
+
+	if !t.After(time.Now()) {
+		return nil, errors.New("expires_at must be in the future")
+	}
+
+	t = t.UTC()
+	return &t, nil
+}
+
+// ParseActiveFrom parses an optional RFC3339 activation time. An empty
+// string means the link is active immediately and yields a nil time.
+func ParseActiveFrom(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, errors.New("active_from must be an RFC3339 timestamp")
+	}
+
+	t = t.UTC()
+	return &t, nil
+}
+
 func IsBase62(code string) bool {
 	return base62Regex.MatchString(code)
 }
@@ -81,3 +168,50 @@ func HashToBase62(input string) string {
 
 	return code
 }
+
+// GetEnv returns the value of the environment variable, or def when it is unset.
+func GetEnv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// GetEnvInt reads a positive integer from the environment, falling back to
+// def when the variable is unset or invalid.
+func GetEnvInt(key string, def int) int {
+	v, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || v <= 0 {
+		return def
+	}
+	return v
+}
+
+// GetEnvBool reports whether the environment variable is set to a true value
+// as understood by strconv.ParseBool.
+func GetEnvBool(key string) bool {
+	v, err := strconv.ParseBool(os.Getenv(key))
+	return err == nil && v
+}
+
+// GetEnvDuration reads a positive time.Duration such as "24h" from the
+// environment, falling back to def when the variable is unset or invalid.
+func GetEnvDuration(key string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || v <= 0 {
+		return def
+	}
+	return v
+}
+
+// SplitList splits a comma separated list, trimming spaces and dropping
+// empty items.
+func SplitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
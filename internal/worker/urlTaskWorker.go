@@ -4,14 +4,112 @@ import (
 	"fmt"
 	"github.com/hbrawnak/go-linko/internal/data"
 	"github.com/hbrawnak/go-linko/internal/database"
+	"github.com/hbrawnak/go-linko/internal/metadata"
 	"github.com/hbrawnak/go-linko/internal/service"
+	"github.com/hbrawnak/go-linko/internal/targeting"
 	"log"
+	"strconv"
 	"time"
 )
 
 type URLTask struct {
-	ShortCode   string
-	OriginalURL string
+	ShortCode      string
+	OriginalURL    string
+	NormalizedHash string
+	PasswordHash   string
+	OwnerID        string
+	MaxClicks      int64
+	RedirectStatus int
+	ForwardQuery   string
+	ForwardPath    bool
+	QueryParams    map[string]string
+	QueryOverride  bool
+	Targets        []targeting.Rule
+	Languages      map[string]string
+	Variants       []targeting.Variant
+	Rotation       string
+	DeepLink       *targeting.DeepLink
+	Interstitial   *bool
+	OpenGraph      *metadata.OpenGraph
+	ActiveFrom     *time.Time
+	ExpiresAt      *time.Time
+	FallbackURL    string
+}
+
+// Plain reports whether the link only redirects to its URL, without
+// schedule, restrictions or redirect options, which makes it
+// interchangeable with links to the same destination.
+func (t URLTask) Plain() bool {
+	return t.ActiveFrom == nil && t.ExpiresAt == nil && t.PasswordHash == "" && t.MaxClicks == 0 &&
+		t.RedirectStatus == 0 && t.ForwardQuery == "" && !t.ForwardPath && len(t.QueryParams) == 0 && len(t.Targets) == 0 &&
+		len(t.Languages) == 0 && len(t.Variants) == 0 && t.DeepLink == nil &&
+		t.Interstitial == nil && t.OpenGraph == nil
+}
+
+// url returns the row persisted for the task.
+func (t URLTask) url() data.URL {
+	return data.URL{
+		ShortCode:           t.ShortCode,
+		OriginalURL:         t.OriginalURL,
+		NormalizedHash:      t.NormalizedHash,
+		PasswordHash:        t.PasswordHash,
+		OwnerID:             t.OwnerID,
+		MaxClicks:           t.MaxClicks,
+		RedirectStatus:      t.RedirectStatus,
+		ForwardQuery:        t.ForwardQuery,
+		ForwardPath:         t.ForwardPath,
+		QueryParams:         t.QueryParams,
+		QueryParamsOverride: t.QueryOverride,
+		Targets:             t.Targets,
+		Languages:           t.Languages,
+		Variants:            t.Variants,
+		Rotation:            t.Rotation,
+		DeepLink:            t.DeepLink,
+		Interstitial:        t.Interstitial,
+		OpenGraph:           t.OpenGraph,
+		ActiveFrom:          t.ActiveFrom,
+		ExpiresAt:           t.ExpiresAt,
+		FallbackURL:         t.FallbackURL,
+	}
+}
+
+// CachedFields returns the cache representation of the persisted link.
+func (t URLTask) CachedFields() database.CachedURL {
+	fields := database.CachedURL{
+		URL:          t.OriginalURL,
+		Persisted:    "1",
+		PasswordHash: t.PasswordHash,
+	}
+	if t.ExpiresAt != nil {
+		fields.ExpiresAt = t.ExpiresAt.Format(time.RFC3339)
+	}
+	if t.ActiveFrom != nil {
+		fields.ActiveFrom = t.ActiveFrom.Format(time.RFC3339)
+		fields.FallbackURL = t.FallbackURL
+	}
+	if t.MaxClicks > 0 {
+		fields.MaxClicks = strconv.FormatInt(t.MaxClicks, 10)
+	}
+	if t.RedirectStatus != 0 {
+		fields.RedirectStatus = strconv.Itoa(t.RedirectStatus)
+	}
+	fields.ForwardQuery = t.ForwardQuery
+	if t.ForwardPath {
+		fields.ForwardPath = "1"
+	}
+	fields.QueryParams = database.JSONField(t.QueryParams)
+	fields.Targets = database.JSONField(t.Targets)
+	fields.Languages = database.JSONField(t.Languages)
+	fields.Variants = database.JSONField(t.Variants)
+	fields.Rotation = t.Rotation
+	fields.DeepLink = database.JSONField(t.DeepLink)
+	fields.Interstitial = database.BoolField(t.Interstitial)
+	fields.OpenGraph = database.JSONField(t.OpenGraph)
+	fields.OwnerID = t.OwnerID
+	if t.QueryOverride {
+		fields.QueryOverride = "1"
+	}
+	return fields
 }
 
 func StartURLTaskWorker(taskQueue <-chan URLTask, service *service.Service) {
@@ -33,15 +131,9 @@ func processURLTask(task URLTask, service *service.Service) error {
 	const maxRetries = 3
 	retryDelay := 200 * time.Millisecond
 
-	u := data.URL{
-		ShortCode:   task.ShortCode,
-		OriginalURL: task.OriginalURL,
-	}
+	u := task.url()
 
-	fields := database.CachedURL{
-		URL:       task.OriginalURL,
-		Persisted: "1",
-	}
+	fields := task.CachedFields()
 
 	var lastErr error
 
@@ -49,11 +141,13 @@ func processURLTask(task URLTask, service *service.Service) error {
 		_, err := service.Models.URL.Insert(u)
 		if err == nil {
 			log.Printf("Insert succeeded in db for shortcode=%s on attempt %d", u.ShortCode, attempt)
-			err := service.Redis.HSet(task.ShortCode, fields.ToMap())
+			err := service.Redis.HSet(task.ShortCode, fields.ToMap(), database.LinkTTL(task.ActiveFrom, task.ExpiresAt))
 			if err != nil {
 				return err
 			}
 			log.Printf("Data persisted successfully for both db and redis %s", u.ShortCode)
+			service.SyncClicks(task.ShortCode, task.MaxClicks)
+			service.FetchMetadataBG(task.ShortCode, task.OwnerID, task.OriginalURL)
 			return nil
 		}
 
@@ -69,3 +163,45 @@ func processURLTask(task URLTask, service *service.Service) error {
 	return fmt.Errorf("failed to insert URL (shortcode=%s, url=%s) after %d attempts: %w",
 		u.ShortCode, u.OriginalURL, maxRetries, lastErr)
 }
+
+// StartURLBatchTaskWorker persists batches produced by the bulk shorten
+// endpoint. Each batch is written in one transaction; if that fails, the
+// tasks are retried one by one so a single bad row does not drop the rest.
+func StartURLBatchTaskWorker(batchQueue <-chan []URLTask, service *service.Service) {
+	log.Println("Batch worker started and listening for tasks...")
+
+	go func() {
+		for batch := range batchQueue {
+			log.Printf("Processing batch of %d tasks", len(batch))
+			processURLBatch(batch, service)
+		}
+	}()
+}
+
+func processURLBatch(batch []URLTask, service *service.Service) {
+	urls := make([]data.URL, 0, len(batch))
+	for _, task := range batch {
+		urls = append(urls, task.url())
+	}
+
+	if err := service.Models.URL.InsertMany(urls); err != nil {
+		log.Printf("Bulk insert of %d urls failed, falling back to single inserts: %v", len(urls), err)
+		for _, task := range batch {
+			if err := processURLTask(task, service); err != nil {
+				log.Printf("Error processing task %s: %v", task.ShortCode, err)
+			}
+		}
+		return
+	}
+
+	for _, task := range batch {
+		fields := task.CachedFields()
+		if err := service.Redis.HSet(task.ShortCode, fields.ToMap(), database.LinkTTL(task.ActiveFrom, task.ExpiresAt)); err != nil {
+			log.Printf("Failed to mark %s as persisted in redis: %v", task.ShortCode, err)
+		}
+		service.SyncClicks(task.ShortCode, task.MaxClicks)
+		service.FetchMetadataBG(task.ShortCode, task.OwnerID, task.OriginalURL)
+	}
+
+	log.Printf("Successfully persisted batch of %d tasks", len(batch))
+}
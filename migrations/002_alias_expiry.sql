@@ -0,0 +1,3 @@
+--- Allow custom aliases and optional link expiry
+ALTER TABLE urls ALTER COLUMN short_code TYPE varchar(32);
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP NULL;
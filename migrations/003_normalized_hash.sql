@@ -0,0 +1,3 @@
+--- Index destinations by normalized URL hash for reuse_existing lookups
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS normalized_hash CHAR(64) NULL;
+CREATE INDEX IF NOT EXISTS idx_urls_normalized_hash ON urls (normalized_hash) WHERE expires_at IS NULL;
@@ -0,0 +1,14 @@
+--- API keys and per-key ownership of links
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    owner_id varchar(64) NOT NULL,
+    name varchar(255) NOT NULL DEFAULT '',
+    key_hash CHAR(64) NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    revoked_at TIMESTAMP NULL
+);
+
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS owner_id varchar(64) NULL;
+
+DROP INDEX IF EXISTS idx_urls_normalized_hash;
+CREATE INDEX IF NOT EXISTS idx_urls_owner_normalized_hash ON urls (owner_id, normalized_hash) WHERE expires_at IS NULL;
@@ -0,0 +1,27 @@
+--- Abuse reports, link disabling and admin audit trail
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP NULL;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS disabled_reason TEXT NULL;
+
+CREATE TABLE IF NOT EXISTS reports (
+    id SERIAL PRIMARY KEY,
+    short_code varchar(32) NOT NULL,
+    reason varchar(32) NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    reporter_fingerprint CHAR(64) NOT NULL,
+    status varchar(16) NOT NULL DEFAULT 'open',
+    created_at TIMESTAMP DEFAULT NOW(),
+    resolved_at TIMESTAMP NULL,
+    UNIQUE (short_code, reporter_fingerprint)
+);
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports (status, created_at);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id SERIAL PRIMARY KEY,
+    actor varchar(255) NOT NULL,
+    action varchar(64) NOT NULL,
+    short_code varchar(32) NULL,
+    details TEXT NOT NULL DEFAULT '',
+    remote_addr varchar(64) NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_short_code ON audit_log (short_code);
@@ -0,0 +1,2 @@
+--- Optional bcrypt password required before redirecting
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS password_hash VARCHAR(72) NULL;
@@ -0,0 +1,3 @@
+--- Links that stop redirecting after a number of clicks
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS max_clicks INTEGER NULL;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS clicks_used INTEGER NOT NULL DEFAULT 0;
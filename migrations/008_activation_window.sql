@@ -0,0 +1,3 @@
+--- Scheduled links that only start redirecting at active_from
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS active_from TIMESTAMP NULL;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS fallback_url TEXT NULL;
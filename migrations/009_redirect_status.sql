@@ -0,0 +1,2 @@
+--- Per-link redirect status code; NULL uses the service default
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS redirect_status SMALLINT NULL;
@@ -0,0 +1,3 @@
+--- Forward the request query string and extra path to the destination
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS forward_query VARCHAR(16) NULL;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS forward_path BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,3 @@
+--- Query parameter templates, such as UTM tags, added at redirect time
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS query_params JSONB NULL;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS query_params_override BOOLEAN NOT NULL DEFAULT FALSE;
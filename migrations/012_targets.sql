@@ -0,0 +1,2 @@
+--- Device, OS and browser targeting rules
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS targets JSONB NULL;
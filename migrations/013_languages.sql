@@ -0,0 +1,9 @@
+--- Language-targeted destinations and per-variant click counts
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS languages JSONB NULL;
+
+CREATE TABLE IF NOT EXISTS link_variant_clicks (
+    short_code varchar(32) NOT NULL,
+    variant varchar(64) NOT NULL,
+    clicks BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (short_code, variant)
+);
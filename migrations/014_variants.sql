@@ -0,0 +1,3 @@
+--- Weighted destination variants for A/B rotation
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS variants JSONB NULL;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS rotation varchar(16) NULL;
@@ -0,0 +1,2 @@
+--- Mobile app deep links with store and web fallbacks
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS deep_link JSONB NULL;
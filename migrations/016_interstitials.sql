@@ -0,0 +1,8 @@
+--- Interstitial warning pages per link and per owner
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS interstitial BOOLEAN NULL;
+
+CREATE TABLE IF NOT EXISTS owner_settings (
+    owner_id varchar(64) PRIMARY KEY,
+    interstitial BOOLEAN NOT NULL DEFAULT false,
+    updated_at TIMESTAMP DEFAULT NOW()
+);
@@ -0,0 +1,2 @@
+--- Title, description, Open Graph and favicon of link destinations
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS metadata JSONB NULL;
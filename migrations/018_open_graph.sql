@@ -0,0 +1,2 @@
+--- Custom Open Graph tags shown to link preview crawlers
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS open_graph JSONB NULL;